@@ -0,0 +1,125 @@
+package cachePool
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+AdaptiveLock: SpinLock 在竞争激烈时一直CAS 空转，浪费cpu，甚至可能livelock.
+AdaptiveLock 拿不到锁时分三个阶段:
+1. 指数退避自旋 activeSpin 次，每次自旋只读state(类似PAUSE)，不CAS，避免cache line 来回失效
+2. runtime.Gosched() passiveSpin 次，让出cpu
+3. 还拿不到就park 在信号量上，Unlock 发现有waiter 才唤醒
+持有锁期间不procPin，因为可能会park.
+*/
+const (
+	activeSpin  = 4
+	passiveSpin = 4
+	maxBackoff  = 64
+)
+
+//LockStats only count the slow path, the uncontended Lock() touch nothing but state
+type LockStats struct {
+	Contended uint64 //Lock() num which can't get the lock at first CAS
+	Spins     uint64
+	Yields    uint64
+	Parks     uint64
+}
+
+func (s LockStats) String() string {
+	return fmt.Sprintf("contended:%d, spins:%d, yields:%d, parks:%d",
+		s.Contended, s.Spins, s.Yields, s.Parks)
+}
+
+type AdaptiveLock struct {
+	state   uint32
+	waiters int32
+	once    sync.Once
+	sema    chan struct{} //wake up token, buffered 1
+	stats   LockStats
+}
+
+func NewAdaptiveLock() *AdaptiveLock {
+	return &AdaptiveLock{}
+}
+
+func (l *AdaptiveLock) semaphore() chan struct{} {
+	l.once.Do(func() {
+		l.sema = make(chan struct{}, 1)
+	})
+	return l.sema
+}
+
+func (l *AdaptiveLock) Lock() {
+	if atomic.CompareAndSwapUint32(&l.state, 0, 1) {
+		return
+	}
+	atomic.AddUint64(&l.stats.Contended, 1)
+	l.lockSlow()
+}
+
+func (l *AdaptiveLock) tryLock() bool {
+	return atomic.LoadUint32(&l.state) == 0 && atomic.CompareAndSwapUint32(&l.state, 0, 1)
+}
+
+func (l *AdaptiveLock) lockSlow() {
+	backoff := 1
+	for i := 0; i < activeSpin; i++ {
+		for j := 0; j < backoff && atomic.LoadUint32(&l.state) != 0; j++ {
+		}
+		if backoff < maxBackoff {
+			backoff <<= 1
+		}
+		atomic.AddUint64(&l.stats.Spins, 1)
+		if l.tryLock() {
+			return
+		}
+	}
+	for i := 0; i < passiveSpin; i++ {
+		runtime.Gosched()
+		atomic.AddUint64(&l.stats.Yields, 1)
+		if l.tryLock() {
+			return
+		}
+	}
+	sema := l.semaphore()
+	for {
+		atomic.AddInt32(&l.waiters, 1)
+		//Unlock may have happened before waiters is increased, try again
+		if l.tryLock() {
+			atomic.AddInt32(&l.waiters, -1)
+			return
+		}
+		atomic.AddUint64(&l.stats.Parks, 1)
+		<-sema
+		atomic.AddInt32(&l.waiters, -1)
+		if l.tryLock() {
+			return
+		}
+	}
+}
+
+//Unlock() must be after Lock(), or will painc
+func (l *AdaptiveLock) Unlock() {
+	if !atomic.CompareAndSwapUint32(&l.state, 1, 0) {
+		panic("adaptive lock unlock fail")
+	}
+	if atomic.LoadInt32(&l.waiters) > 0 {
+		select {
+		case l.semaphore() <- struct{}{}:
+		default: //there is a token already
+		}
+	}
+}
+
+func (l *AdaptiveLock) Stats() LockStats {
+	return LockStats{
+		Contended: atomic.LoadUint64(&l.stats.Contended),
+		Spins:     atomic.LoadUint64(&l.stats.Spins),
+		Yields:    atomic.LoadUint64(&l.stats.Yields),
+		Parks:     atomic.LoadUint64(&l.stats.Parks),
+	}
+}
@@ -0,0 +1,48 @@
+package cachePool
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+//compare sync.Mutex, SpinLock and AdaptiveLock under contention:
+//go test -run ^$ -bench BenchmarkLock -cpu 1,4,8
+//go test -tags cachepool_procpin -run ^$ -bench BenchmarkLock -cpu 1,4,8
+func BenchmarkLock(b *testing.B) {
+	locks := []struct {
+		name string
+		new  func() sync.Locker
+	}{
+		{"Mutex", func() sync.Locker { return &sync.Mutex{} }},
+		{"SpinLock", func() sync.Locker { return NewSpinLock() }},
+		{"AdaptiveLock", func() sync.Locker { return NewAdaptiveLock() }},
+	}
+	for _, work := range []int{0, 10, 100} {
+		for _, lk := range locks {
+			b.Run(fmt.Sprintf("%s/work=%d", lk.name, work), func(b *testing.B) {
+				benchmarkLock(b, lk.new(), work)
+			})
+		}
+	}
+}
+
+//work is the loop num in critical section
+func benchmarkLock(b *testing.B, l sync.Locker, work int) {
+	shared := 0
+	b.SetParallelism(4)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.Lock()
+			for i := 0; i < work; i++ {
+				shared++
+			}
+			l.Unlock()
+		}
+	})
+	if al, ok := l.(*AdaptiveLock); ok {
+		st := al.Stats()
+		b.ReportMetric(float64(st.Contended)/float64(b.N), "contended/op")
+		b.ReportMetric(float64(st.Parks)/float64(b.N), "parks/op")
+	}
+}
@@ -0,0 +1,230 @@
+package cachePool
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+//batch size of one positioner operation, EntryHeader array of this size is on the stack
+const batchSize = 64
+
+//get up to len(dst) entries from pool with one lock acquisition or CAS per batchSize
+func (p *Pool) GetEntries(dst []*Entry) int {
+	if p.isDraining() {
+		return 0
+	}
+	var ehs [batchSize]EntryHeader
+	got := 0
+	for got < len(dst) {
+		want := len(dst) - got
+		if want > batchSize {
+			want = batchSize
+		}
+		n := p.positioner.GetEntryHeaders(ehs[:want])
+		p.addUsed(n)
+		if p.putBackIfDraining(ehs[:n]) {
+			break
+		}
+		for i := 0; i < n; i++ {
+			entry := p.entryAt(ehs[i].entryId)
+			if entry.isUsed() {
+				panic("GetEntries: entry have been used?")
+			}
+			atomic.StoreUint32(&entry.nextFree, entry.nextFree|UsedFlag)
+			if debugBuild && p.isValuePool() {
+				debugCheckAlloc(p, entry)
+			}
+			p.trackAlloc(entry)
+			dst[got] = entry
+			got++
+		}
+		if n < want {
+			break
+		}
+	}
+	return got
+}
+
+//put entries back to pool with one lock acquisition or CAS per batchSize,
+//all entries must belong to this pool
+func (p *Pool) PutEntries(es []*Entry) int {
+	var ehs [batchSize]*EntryHeader
+	put := 0
+	for len(es) > 0 {
+		n := len(es)
+		if n > batchSize {
+			n = batchSize
+		}
+		for i := 0; i < n; i++ {
+			ehs[i] = &es[i].EntryHeader
+		}
+		put += p.positioner.PutEntryHeaders(ehs[:n])
+		es = es[n:]
+	}
+	p.addUsed(-put)
+	return put
+}
+
+//GetValues fill dst with free values, return the num of values got,
+//it is less than len(dst) only when the cachePool can't extend any more
+func (cp *cachePool) GetValues(dst []*Value) int {
+	var es [batchSize]*Entry
+	got := 0
+	pools := cp.loadPools()
+	max := len(pools)
+	start := cp.selectPool(pools, 0, false)
+	for i := 0; i < max && got < len(dst); i++ {
+		p := pools[(start+i)%max]
+		if p == nil {
+			continue
+		}
+		for got < len(dst) {
+			want := len(dst) - got
+			if want > batchSize {
+				want = batchSize
+			}
+			n := p.GetEntries(es[:want])
+			for j := 0; j < n; j++ {
+				dst[got] = &es[j].Value
+				got++
+			}
+			if n < want {
+				break
+			}
+		}
+	}
+	//all pools are empty, GetValue() will extend the cachePool if autoExtend
+	for ; got < len(dst); got++ {
+		v := cp.GetValue()
+		if v == nil {
+			break
+		}
+		dst[got] = v
+	}
+	return got
+}
+
+//PutValues put values back, consecutive values of the same pool are put with one
+//lock acquisition or CAS, so it's better to put the values got by GetValues together.
+//it return the num of values put back and the first error of the others, see PutValue,
+//nil values are skipped
+func (cp *cachePool) PutValues(vs []*Value) (int, error) {
+	var es [batchSize]*Entry
+	n, put := 0, 0
+	poolId := uint32(0)
+	var err error
+	fail := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+	flush := func() {
+		if n > 0 {
+			p := cp.getPool(poolId)
+			k := p.PutEntries(es[:n])
+			put += k
+			if k < n {
+				fail(ErrPutFailed)
+			}
+			if k > 0 {
+				cp.handoffPool(p)
+			}
+			n = 0
+		}
+	}
+	for _, v := range vs {
+		if v == nil {
+			continue
+		}
+		if cp.findPool(v) == nil {
+			atomic.AddUint64(&cp.foreignPuts, 1)
+			fail(ErrForeignPointer)
+			continue
+		}
+		e := GetEntryFromElem(v)
+		deferred, derr := cp.deferFree(e)
+		if derr != nil {
+			fail(derr)
+			continue
+		}
+		if deferred {
+			put++
+			continue
+		}
+		if !e.clearUsed() {
+			atomic.AddUint64(&cp.doubleFrees, 1)
+			fail(ErrDoubleFree)
+			continue
+		}
+		atomic.AddUint32(&e.gen, 1)
+		if !cp.getPool(e.poolId).isDraining() && cp.waitq.handoff(e) {
+			put++
+			continue
+		}
+		if debugBuild {
+			debugPoison(cp.getPool(e.poolId), e)
+		}
+		if n == batchSize || (n > 0 && e.poolId != poolId) {
+			flush()
+		}
+		poolId = e.poolId
+		es[n] = e
+		n++
+	}
+	flush()
+	return put, err
+}
+
+func (sm *poolShardMap) shardOf(key *Key) int {
+	return key.Hash() & sm.shardMask
+}
+
+//group keys by shard, return the indexes of keys in shard order
+func (sm *poolShardMap) groupByShard(keys []Key) []int32 {
+	counts := make([]int32, sm.shardSize+1)
+	for i := range keys {
+		counts[sm.shardOf(&keys[i])+1]++
+	}
+	for i := 1; i < len(counts); i++ {
+		counts[i] += counts[i-1]
+	}
+	order := make([]int32, len(keys))
+	for i := range keys {
+		s := sm.shardOf(&keys[i])
+		order[counts[s]] = int32(i)
+		counts[s]++
+	}
+	return order
+}
+
+//StoreBatch store keys[i] --> vs[i] with one lock acquisition, keys are grouped by shard.
+//nothing is stored if len(vs) != len(keys) or any value is nil
+func (cp *cachePool) StoreBatch(keys []Key, vs []*Value) error {
+	if len(vs) != len(keys) {
+		return fmt.Errorf("StoreBatch: %d keys but %d values", len(keys), len(vs))
+	}
+	for i, v := range vs {
+		if v == nil {
+			return fmt.Errorf("StoreBatch: value of key %v is nil", keys[i])
+		}
+	}
+	cp.sm.Lock()
+	//shards may be changed by Reshard, group them under lock
+	order := cp.sm.groupByShard(keys)
+	for _, i := range order {
+		cp.sm.set(&keys[i], GetElemID(vs[i]))
+	}
+	cp.sm.Unlock()
+	return nil
+}
+
+//DeleteBatch delete keys with one lock acquisition, keys are grouped by shard,
+//values are not put back, like Delete()
+func (cp *cachePool) DeleteBatch(keys []Key) {
+	cp.sm.Lock()
+	order := cp.sm.groupByShard(keys)
+	for _, i := range order {
+		cp.sm.del(&keys[i])
+	}
+	cp.sm.Unlock()
+}
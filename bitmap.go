@@ -0,0 +1,206 @@
+package cachePool
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+bitmap position: slots 和 ring 每个entry 都要一个EntryHeader(12 bytes)来记录位置，pool 很大时元数据也很大，
+bitmap 每个entry 只用1 bit, 第i 位是1 表示第i 个entry 空闲, entryId 就是i。
+summary 是第二层的bitmap, summary 第j 位是1 表示free[j] 可能有空闲的entry，Get 时先查summary, 不用扫描整个free。
+
+free[j] 和 summary 都用CAS 修改, 不需要锁:
+	Put: 先设置free[j] 的位，再设置summary 的第j 位
+	Get: summary 第j 位是1 但free[j] 是0 时，清掉summary 第j 位，然后再检查一次free[j],
+	     如果这时有Put 进来的entry，重新设置summary 第j 位，所以没有并发时summary 不会漏掉空闲的entry
+*/
+
+type bitmapPosition struct {
+	getRace uint64
+	_       CachePad
+	poolId  uint32
+	cap     uint32
+	free    []uint64 //1 bit per entry, 1 means free
+	summary []uint64 //1 bit per free word, 1 means the word may have free bit
+}
+
+func (b *bitmapPosition) InitPosition(buffer []byte, poolIndex, cap, entrySize int) error {
+	b.poolId = uint32(poolIndex)
+	b.cap = uint32(cap)
+	b.free = make([]uint64, (cap+63)/64)
+	b.summary = make([]uint64, (len(b.free)+63)/64)
+	for i := 0; i < cap; i++ {
+		eh := (*EntryHeader)(unsafe.Pointer(&buffer[i*entrySize]))
+		eh.poolId = uint32(poolIndex)
+		eh.entryId = uint32(i)
+		eh.nextFree = 0
+	}
+	for j := range b.free {
+		b.free[j] = ^uint64(0)
+		if n := cap - j*64; n < 64 {
+			b.free[j] = 1<<uint(n) - 1
+		}
+		b.summary[j/64] |= 1 << uint(j%64)
+	}
+	return nil
+}
+
+func (b *bitmapPosition) String() string {
+	free := 0
+	for j := range b.free {
+		free += bits.OnesCount64(atomic.LoadUint64(&b.free[j]))
+	}
+	return fmt.Sprintf("bitmap, cap:%d, free:%d, words:%d, summary words:%d, getRace:%d",
+		b.cap, free, len(b.free), len(b.summary), atomic.LoadUint64(&b.getRace))
+}
+
+//return bit index of entry, false if eh is not an entry of this pool
+func (b *bitmapPosition) indexOf(eh *EntryHeader) (uint32, bool) {
+	if eh.poolId != b.poolId {
+		return 0, false
+	}
+	return eh.entryId, eh.entryId < b.cap
+}
+
+func (b *bitmapPosition) header(i uint32) EntryHeader {
+	return EntryHeader{entryPosition: entryPosition{poolId: b.poolId, entryId: i}}
+}
+
+//set bits of mask, return the num of bits which were 0
+func setBits(addr *uint64, mask uint64) int {
+	for {
+		old := atomic.LoadUint64(addr)
+		if old|mask == old {
+			return 0
+		}
+		if atomic.CompareAndSwapUint64(addr, old, old|mask) {
+			return bits.OnesCount64(mask &^ old)
+		}
+	}
+}
+
+func clearBits(addr *uint64, mask uint64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if old&mask == 0 || atomic.CompareAndSwapUint64(addr, old, old&^mask) {
+			return
+		}
+	}
+}
+
+//clear at most k lowest free bits of free[j] with one CAS, return the bits cleared
+func (b *bitmapPosition) claim(j int, k int) uint64 {
+	for {
+		old := atomic.LoadUint64(&b.free[j])
+		left := old
+		for n := 0; n < k && left != 0; n++ {
+			left &= left - 1 //clear the lowest 1 bit
+		}
+		if old == left {
+			return 0
+		}
+		if atomic.CompareAndSwapUint64(&b.free[j], old, left) {
+			return old &^ left
+		}
+		atomic.AddUint64(&b.getRace, 1)
+	}
+}
+
+//mark free[j] empty in summary, and mark it again if a Put come in meanwhile
+func (b *bitmapPosition) emptyWord(j int) bool {
+	clearBits(&b.summary[j/64], 1<<uint(j%64))
+	if atomic.LoadUint64(&b.free[j]) == 0 {
+		return true
+	}
+	setBits(&b.summary[j/64], 1<<uint(j%64))
+	return false
+}
+
+//take at most len(dst) free entries, start from the summary word of current P to spread the contention
+func (b *bitmapPosition) take(dst []EntryHeader) int {
+	n := 0
+	ns := len(b.summary)
+	if ns == 0 {
+		return 0
+	}
+	start := getPid() % ns
+	for s := 0; s < ns && n < len(dst); s++ {
+		si := (start + s) % ns
+		for sw := atomic.LoadUint64(&b.summary[si]); sw != 0 && n < len(dst); {
+			j := si*64 + bits.TrailingZeros64(sw)
+			got := b.claim(j, len(dst)-n)
+			if got == 0 {
+				if b.emptyWord(j) {
+					sw &= sw - 1
+				}
+				continue
+			}
+			for ; got != 0; got &= got - 1 {
+				dst[n] = b.header(uint32(j*64 + bits.TrailingZeros64(got)))
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (b *bitmapPosition) GetEntryHeader() EntryHeader {
+	var eh [1]EntryHeader
+	if b.take(eh[:]) == 0 {
+		return InvalidEntryHeader
+	}
+	return eh[0]
+}
+
+func (b *bitmapPosition) GetEntryHeaders(dst []EntryHeader) int {
+	if len(dst) == 0 {
+		return 0
+	}
+	return b.take(dst)
+}
+
+//here Entry is pool buffer'Entry, false if it is free already
+func (b *bitmapPosition) PutEntryHeader(e *EntryHeader) bool {
+	i, ok := b.indexOf(e)
+	if !ok {
+		return false
+	}
+	e.nextFree &= IdMask
+	if setBits(&b.free[i/64], 1<<(i%64)) == 0 {
+		return false
+	}
+	setBits(&b.summary[i/64/64], 1<<(i/64%64))
+	return true
+}
+
+//entries in the same word are put back with one CAS
+func (b *bitmapPosition) PutEntryHeaders(ehs []*EntryHeader) int {
+	n := 0
+	j, mask := -1, uint64(0)
+	flush := func() {
+		if mask == 0 {
+			return
+		}
+		if k := setBits(&b.free[j], mask); k > 0 {
+			n += k
+			setBits(&b.summary[j/64], 1<<uint(j%64))
+		}
+	}
+	for _, e := range ehs {
+		i, ok := b.indexOf(e)
+		if !ok {
+			continue
+		}
+		e.nextFree &= IdMask
+		if int(i/64) != j {
+			flush()
+			j, mask = int(i/64), 0
+		}
+		mask |= 1 << (i % 64)
+	}
+	flush()
+	return n
+}
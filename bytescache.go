@@ -0,0 +1,128 @@
+package cachePool
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+BytesCache: key []byte --> value []byte 的缓存，跟bigcache 的接口类似，方便bigcache 的使用者迁移，
+key 和 value 都copy 到size class pool 的entry 里，Get 返回的value 直接指向entry 里的内存，读的时候没有copy。
+BytesCache 有自己的shardMap, 保存的是 Key{A: fnv64a(key)} --> elemID，不和cachePool.Store 的key 放在一起，
+否则hash 刚好等于某个Value 的key 时会覆盖它的映射，Value 就泄漏了。hash 冲突时，Get 会比较entry 里保存的key，
+不相等就当作没找到，Set 直接覆盖，跟bigcache 一样。
+
+entry payload layout:
+	| keyLen uint32 | key | value |
+*/
+
+const keyLenSize = 4
+
+type BytesCache struct {
+	cp     *cachePool
+	sm     *poolShardMap //keys of BytesCache only
+	hasher Hasher
+}
+
+//NewBytesCache make a BytesCache on the size class pools of cp, its shardMap has
+//the same shard num as cp's, cp must be created with OptionWithSizeClasses
+func NewBytesCache(cp *cachePool) (*BytesCache, error) {
+	if len(cp.classes) == 0 {
+		return nil, fmt.Errorf("cachePool have no size class pools")
+	}
+	sm, err := NewShardMap(cp.ShardSize())
+	if err != nil {
+		return nil, err
+	}
+	return &BytesCache{cp: cp, sm: sm, hasher: newDefaultHasher()}, nil
+}
+
+//Reshard change the num of shards of BytesCache, see cachePool.Reshard
+func (c *BytesCache) Reshard(n int) error {
+	return c.sm.reshard(n)
+}
+
+func (c *BytesCache) hashKey(key []byte) Key {
+	return Key{A: int(c.hasher.Sum64(bytesToString(key)))}
+}
+
+//Set copy key and val into an entry, the old entry of key is freed
+func (c *BytesCache) Set(key, val []byte) error {
+	n := keyLenSize + len(key) + len(val)
+	b := c.cp.GetBytes(n)
+	if b == nil {
+		return fmt.Errorf("can't get bytes of size:%d, too large or no free entry", n)
+	}
+	binary.LittleEndian.PutUint32(b, uint32(len(key)))
+	copy(b[keyLenSize:], key)
+	copy(b[keyLenSize+len(key):], val)
+	_, h, err := c.cp.bytesEntry(b)
+	if err != nil {
+		return err
+	}
+	elemID := h.elemID()
+
+	k := c.hashKey(key)
+	sm := c.sm
+	sm.Lock()
+	old, ok := sm.get(&k)
+	sm.set(&k, elemID)
+	sm.Unlock()
+	//only bytes entries are stored in c.sm, check it anyway before free
+	if ok && isBytesElemID(old) {
+		c.cp.freeBytesElemID(old)
+	}
+	return nil
+}
+
+//split payload to key and value
+func splitKeyValue(b []byte) ([]byte, []byte, bool) {
+	if len(b) < keyLenSize {
+		return nil, nil, false
+	}
+	kl := int(binary.LittleEndian.Uint32(b))
+	if keyLenSize+kl > len(b) {
+		return nil, nil, false
+	}
+	return b[keyLenSize : keyLenSize+kl], b[keyLenSize+kl:], true
+}
+
+//Get return the value in entry without copy, it is invalid after the key is Set or Deleted
+func (c *BytesCache) Get(key []byte) ([]byte, bool) {
+	k := c.hashKey(key)
+	sm := c.sm
+	sm.RLock()
+	elemID, ok := sm.get(&k)
+	sm.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	ekey, val, ok := splitKeyValue(c.cp.getBytesFromElemID(elemID))
+	if !ok || !bytes.Equal(ekey, key) {
+		//hash collision
+		return nil, false
+	}
+	return val, true
+}
+
+//Delete delete the key and free its entry, return false if key is not exist
+func (c *BytesCache) Delete(key []byte) bool {
+	k := c.hashKey(key)
+	sm := c.sm
+	sm.Lock()
+	elemID, ok := sm.get(&k)
+	if ok {
+		ekey, _, valid := splitKeyValue(c.cp.getBytesFromElemID(elemID))
+		//don't delete other key with the same hash
+		ok = valid && bytes.Equal(ekey, key)
+	}
+	if ok {
+		sm.del(&k)
+	}
+	sm.Unlock()
+	if !ok {
+		return false
+	}
+	return c.cp.freeBytesElemID(elemID)
+}
@@ -21,15 +21,19 @@ all: no pointer in key or value, or value's pointer will not be gc when cachePoo
      2. map[key]positionID ,positionID indicate the buffer position, so can get the value
 */
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"math"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"unsafe"
 )
 
 const (
-	MaxPoolSize = 1<<31 - 1
+	MaxPoolSize = 1<<31 - 1 //max entry num of a pool, entryId and slot index must be less than Invalid
+	MaxPoolNum  = 1 << 24   //max Value pool num, the poolIds above it belong to size class pools
 	IdMask      = 1<<31 - 1
 	HigestBit   = 1 << 31 //entry used flag bit, idleSlot init value is HigestBit, int is
 	UsedFlag    = HigestBit
@@ -38,6 +42,12 @@ const (
 
 var InvalidEntryHeader EntryHeader
 
+var (
+	ErrDoubleFree     = errors.New("value has been put back already")
+	ErrForeignPointer = errors.New("value is not from any pool buffer")
+	ErrPutFailed      = errors.New("entry is freed but its pool refuse it, the pool is full or released")
+)
+
 var useSlots bool
 
 func init() {
@@ -47,7 +57,7 @@ func init() {
 
 type entryPosition struct {
 	poolId  uint32 //uint8,后面才想到需要这个poolid, 其实可以把using flag 放到这里
-	entryId uint32 //pool buffer index
+	entryId uint32 //index of entry in pool buffer, not byte offset, so buffer can be larger than 4GiB
 }
 
 type EntryHeader struct {
@@ -65,7 +75,10 @@ type EntryHeader struct {
 
 //like list_head,  put the list_head on the first position of entry node
 type Entry struct {
-	EntryHeader //first member
+	EntryHeader        //first member
+	state       uint32 //lease count and freePending flag, read write spinlock of Value, see lease.go, entrylock.go
+	seq         uint32 //sequence counter of Value, odd means writing, see ReadConsistent
+	gen         uint32 //generation, changed when entry is freed or moved by Compact
 	//user data Value
 	Value
 }
@@ -84,16 +97,47 @@ func (k *Key) Hash() int {
 }
 
 type CachePoolConf struct {
-	poolNum    int
+	poolNum    int //initial pool num, GetPoolNum is the current one
 	poolCap    int
 	autoExtend bool
 	maxPool    int
 	shardSize  int
+
+	localCacheSize int          //per-P free entry magazine size, 0 means disable
+	selector       PoolSelector //chose the pool GetValue start from, default PerPSelector
+	numa           bool         //group pools by NUMA node
+
+	classMin     int //size class pools, see OptionWithSizeClasses
+	classMax     int
+	classPoolCap int
+
+	leakTracking bool //record allocation time and caller of entries, see LeakReport
+
+	positioner PositionerKind //EntryPositioner of pools, default is decided by flag useSlots
+
+	growth      GrowthPolicy //cap of the pool added when all pools are full, default is poolCap
+	maxCapacity int          //limit of the sum of cap of pools, 0 means no limit
+	memoryLimit int64        //limit of bytes of pools, 0 means no limit, see OptionWithMemoryLimit
+	logLevel    LogLevel     //LogDefault means the global one of SetLogLevel
+	//autoExtend, maxPool, growth, maxCapacity and memoryLimit are the initial ones, hot paths read liveConf
 }
 
 type cachePool struct {
-	pools []*Pool
-	sm    *poolShardMap
+	table      atomic.Pointer[poolTable] //Value pools, see pooltable.go
+	liveConfig atomic.Pointer[liveConf]  //settings can be changed by Reconfigure, see reconfig.go
+	spans      atomic.Pointer[poolSpans] //buffer address index of all pools, see pooltable.go
+	spansMu    sync.Mutex
+	sm         *poolShardMap
+	locals     []localCache //per-P free entry cache
+	waitq      waitQueue    //goroutines waiting in GetValueCtx
+
+	doubleFrees uint64 //num of PutValue rejected by ErrDoubleFree
+	foreignPuts uint64 //num of PutValue rejected by ErrForeignPointer
+
+	memoryUsed      int64  //bytes of pools accounted for memoryLimit
+	memoryLimitHits uint64 //num of extension shrunk or refused by memory limit
+	//size class pools for GetBytes
+	classes []*sizeClass
 	sync.Mutex
 	CachePoolConf
 }
@@ -104,14 +148,24 @@ type EntryPositioner interface {
 	InitPosition(buffer []byte, poolIndex, cap, entrySize int) error
 	PutEntryHeader(*EntryHeader) bool //put entry position to ring buffer or slot
 	GetEntryHeader() EntryHeader      //get available entry's position from ring buffer or slot
+
+	//batch version, only one lock acquisition or CAS for many entries
+	PutEntryHeaders([]*EntryHeader) int //return the num of entry positions put
+	GetEntryHeaders([]EntryHeader) int  //return the num of available entry positions got
 }
 
 type Pool struct {
 	//sync.RWMutex
-	index    int
-	size     uint32 //entry num
-	buffer   []byte
-	useSlots bool
+	used      int64 //entry num taken from positioner, first field, so it is 8 bytes aligned on 32 bit platforms
+	index     int
+	size      uint32 //entry num
+	entrySize int
+	node      int //NUMA node of buffer
+	buffer    []byte
+	kind      PositionerKind
+	leaks     []leakRecord //side array for leak tracking, nil if disable
+	draining  uint32       //1 when Compact is moving entries out, no entry is allocated from it
+	debug     poolDebug    //alloc records of cachepool_debug build, see debug_on.go
 
 	positioner EntryPositioner
 	//use slots for pool
@@ -127,6 +181,11 @@ type poolShardMap struct {
 	shardSize int
 	shardMask int
 	maps      []map[Key]uint64
+
+	//old shards during Reshard, see shardmap.go
+	old       []map[Key]uint64
+	oldMask   int
+	reshardMu sync.Mutex //one Reshard at a time
 }
 
 var offset uintptr
@@ -134,7 +193,7 @@ var entrySize int
 
 func init() {
 	e := Entry{}
-	entrySize = int(unsafe.Sizeof(e))
+	entrySize = int(unsafe.Sizeof(e)+7) &^ 7 //36 bytes on 32 bit platforms, keep entries 8 bytes aligned
 	offset = unsafe.Offsetof(e.Value)
 }
 
@@ -145,6 +204,21 @@ func (e *Entry) String() string {
 func (e *EntryHeader) isUsed() bool {
 	return e.nextFree&UsedFlag != 0
 }
+
+//clean UsedFlag lockless, return false if it has been cleaned by others
+func (e *EntryHeader) clearUsed() bool {
+	for {
+		flag := atomic.LoadUint32(&e.nextFree)
+		if flag&UsedFlag == 0 { //have clean UsedFlag, means it has been put back to pool
+			return false
+		}
+		//clean UsedFlag
+		newflag := flag & (UsedFlag - 1)
+		if atomic.CompareAndSwapUint32(&e.nextFree, flag, newflag) {
+			return true
+		}
+	}
+}
 func (e *EntryHeader) String() string {
 	return fmt.Sprintf("entryheader:pid=%d, entryId=%d, nexfree slot=%d, valid=%v", e.poolId, e.entryId, e.nextFree&IdMask, !e.invalid())
 }
@@ -172,79 +246,181 @@ func OptionWithShardSize(n int) Option {
 	}
 }
 
+//Check return a descriptive error for the first config out of its limit
 func (c *CachePoolConf) Check() error {
-	if c.poolCap == 0 || c.shardSize == 0 {
-		return fmt.Errorf("poolCap or shardSize eq 0")
+	if c.poolNum < 0 || c.poolNum > MaxPoolNum {
+		return fmt.Errorf("poolNum:%d out of range [0, %d]", c.poolNum, MaxPoolNum)
+	}
+	if err := checkPoolCap("poolCap", c.poolCap, entrySize); err != nil {
+		return err
+	}
+	if c.shardSize <= 0 || c.shardSize > MaxShardSize {
+		return fmt.Errorf("shardSize:%d out of range [1, %d]", c.shardSize, MaxShardSize)
+	}
+	if c.maxPool < 0 || c.maxPool > MaxPoolNum {
+		return fmt.Errorf("maxPool:%d out of range [0, %d], 0 means no limit", c.maxPool, MaxPoolNum)
+	}
+	if c.maxPool != 0 && c.poolNum > c.maxPool {
+		return fmt.Errorf("poolNum:%d is larger than maxPool:%d", c.poolNum, c.maxPool)
+	}
+	if c.maxCapacity < 0 {
+		return fmt.Errorf("maxCapacity:%d must be >= 0, 0 means no limit", c.maxCapacity)
+	}
+	if c.memoryLimit < 0 {
+		return fmt.Errorf("memoryLimit:%d must be >= 0, 0 means no limit", c.memoryLimit)
+	}
+	if c.localCacheSize < 0 {
+		return fmt.Errorf("localCacheSize:%d must be >= 0, 0 means disable", c.localCacheSize)
+	}
+	if err := checkGrowth(c.growth); err != nil {
+		return err
+	}
+	if c.logLevel < LogDefault || c.logLevel > LogOff {
+		return fmt.Errorf("unknown log level:%s", c.logLevel)
+	}
+	if c.classMax != 0 {
+		if c.classMin <= 0 || c.classMin > c.classMax {
+			return fmt.Errorf("size class min:%d must be in (0, max:%d]", c.classMin, c.classMax)
+		}
+		if c.classMax > maxClassSize {
+			return fmt.Errorf("size class max:%d is larger than %d", c.classMax, maxClassSize)
+		}
+		if err := checkPoolCap("size class poolCap", c.classPoolCap, classEntrySize(CeilToPowerOfTwo(c.classMax))); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+func checkPoolCap(name string, cap, entrySize int) error {
+	if reason := poolCapLimit(cap, entrySize); reason != "" {
+		return fmt.Errorf("%s:%d %s", name, cap, reason)
+	}
+	return nil
+}
+
+//cap*entrySize must not overflow int, it matters on 32 bit platform
+func poolCapLimit(cap, entrySize int) string {
+	if cap <= 0 || cap > MaxPoolSize {
+		return fmt.Sprintf("out of range [1, %d]", MaxPoolSize)
+	}
+	if cap > math.MaxInt/entrySize {
+		return fmt.Sprintf("* entrySize:%d overflows the buffer size", entrySize)
+	}
+	return ""
+}
+
+//default shardSize is eq poolNumInit, but no more than 16
+func defaultShardSize(poolNum int) int {
+	if poolNum > 16 {
+		return 16
+	}
+	return poolNum
+}
+
 func NewCachePool(poolNum, poolCap int, opts ...Option) (cp *cachePool, err error) {
 	cp = new(cachePool)
 	cp.poolCap = poolCap
 
 	cp.autoExtend = true //default
-	if cp.shardSize == 0 {
-		cp.shardSize = poolNum //default shardSize is eq poolNumInit
-	}
-	if cp.shardSize > 16 {
-		cp.shardSize = 16
-	}
+	cp.selector = PerPSelector{}
+	cp.shardSize = defaultShardSize(poolNum)
 
 	for _, opt := range opts {
 		opt(&cp.CachePoolConf)
 	}
+	cp.poolNum = poolNum
 	err = cp.Check()
 	if err != nil {
 		return
 	}
+	cp.liveConfig.Store(cp.CachePoolConf.liveConf())
+	if _, ok := cp.selector.(PerPSelector); ok && cp.numaEnabled() {
+		cp.selector = newNUMASelector(runtime.GOMAXPROCS(0))
+	}
 
-	cp.pools = make([]*Pool, poolNum)
-	for i := 0; i < len(cp.pools); i++ {
-		_, err = cp.NewPool()
+	cp.table.Store(&poolTable{pools: make([]*Pool, poolNum)})
+	for i := 0; i < poolNum; i++ {
+		_, err = cp.addDefaultPool()
 		if err != nil {
 			return
 		}
 	}
 
+	cp.initLocalCaches()
+	err = cp.initSizeClasses()
+	if err != nil {
+		return
+	}
 	cp.sm, err = NewShardMap(cp.shardSize)
 	return
 }
 
+//NewPool add a pool of the default cap, it is safe to call it while cachePool is working
 func (cp *cachePool) NewPool() (p *Pool, err error) {
-	//chose a available slot of cachePool to store the new Pool
-	for i := 0; i < len(cp.pools); i++ {
-		if cp.pools[i] == nil {
-			p, err = NewPool(i, cp.poolCap)
-			if err != nil {
-				return
-			}
-			cp.pools[i] = p
-			cp.poolNum++
-			return
-		}
+	cp.Lock()
+	defer cp.Unlock()
+	return cp.addDefaultPool()
+}
+
+//must be called with cp.Lock() held, or at init
+func (cp *cachePool) addDefaultPool() (p *Pool, err error) {
+	if cp.reserveMemory(cp.live(), cp.poolCap, entrySize, false) == 0 {
+		return nil, ErrMemoryLimit
+	}
+	p, err = cp.addPool(cp.poolCap)
+	if err != nil {
+		cp.releaseMemory(cp.poolCap, entrySize)
 	}
-	//there is no chose available slot, so newPool and append to cachePool
-	p, err = NewPool(len(cp.pools), cp.poolCap)
+	return
+}
+
+//pools can have different cap, see GrowthPolicy
+//the index released by Compact is never reused, or the Handle and elemID of the released pool
+//would resolve to the new pool, so the new pool is always appended, up to MaxPoolNum
+//must be called with cp.Lock() held, or at init
+func (cp *cachePool) addPool(cap int) (p *Pool, err error) {
+	index := len(cp.loadPools())
+	p, err = cp.newNodePool(index, cap)
 	if err != nil {
 		return
 	}
-	cp.pools = append(cp.pools, p)
-	cp.poolNum++
+	cp.setPool(index, p)
+	return
+}
+
+//new pool on the NUMA node of index, buffer is first touched by the thread on that node
+func (cp *cachePool) newNodePool(index, cap int) (p *Pool, err error) {
+	if index >= MaxPoolNum {
+		return nil, fmt.Errorf("pool index:%d is over the max pool num:%d", index, MaxPoolNum)
+	}
+	node := cp.poolNode(index)
+	cp.runOnNode(node, func() {
+		p, err = newPool(index, cap, entrySize, cp.positioner)
+	})
+	if p != nil {
+		p.node = node
+		if debugBuild {
+			debugPoisonPool(p)
+		}
+		if cp.leakTracking {
+			p.leaks = make([]leakRecord, p.size)
+		}
+	}
 	return
 }
 
 func (cp *cachePool) String() string {
-	return fmt.Sprintf("poolNum:%d, poolcap:%d, shardMap size:%d", cp.GetPoolNum(), cp.poolCap, cp.sm.shardSize)
+	return fmt.Sprintf("poolNum:%d, poolcap:%d, capacity:%d, shardMap size:%d", cp.GetPoolNum(), cp.poolCap, cp.Capacity(), cp.ShardSize())
 }
 
 func (cp *cachePool) GetPoolNum() int {
-	return cp.poolNum
+	return cp.table.Load().num
 }
 
 func (cp *cachePool) Capacity() int {
 	capSum := 0
-	for _, pool := range cp.pools {
+	for _, pool := range cp.loadPools() {
 		if pool != nil {
 			capSum += int(pool.Cap())
 		}
@@ -253,55 +429,77 @@ func (cp *cachePool) Capacity() int {
 }
 
 func (cp *cachePool) GetPoolPositioner(i int) EntryPositioner {
-	return cp.pools[i].positioner
+	return cp.loadPools()[i].positioner
 }
 
 func NewPool(index, cap int) (*Pool, error) {
+	return newPool(index, cap, entrySize, PositionerDefault)
+}
+
+//entrySize of Value pool is sizeof(Entry), size class pool has its own entrySize
+func newPool(index, cap, entrySize int, kind PositionerKind) (*Pool, error) {
 	var err error
-	if index < 0 || cap < 0 {
-		return nil, fmt.Errorf("pool index or cap invalid")
+	if index < 0 || index > IdMask {
+		return nil, fmt.Errorf("pool index:%d out of range [0, %d]", index, IdMask)
 	}
-	if cap > MaxPoolSize {
-		return nil, fmt.Errorf("MaxPoolSize is %d", MaxPoolSize)
+	if entrySize < int(unsafe.Sizeof(EntryHeader{})) || entrySize%8 != 0 {
+		return nil, fmt.Errorf("entrySize:%d invalid, it must be a multiple of 8 and hold EntryHeader", entrySize)
+	}
+	if err = checkPoolCap("pool cap", cap, entrySize); err != nil {
+		return nil, err
 	}
 
 	p := &Pool{}
 	p.index = index
 	p.size = uint32(cap)
+	p.entrySize = entrySize
+	p.positioner, p.kind, err = newPositioner(kind)
+	if err != nil {
+		return nil, err
+	}
 	p.buffer = make([]byte, cap*entrySize)
-	p.useSlots = useSlots
-	if p.useSlots {
-		//p.positioner = &p.slotsPosition
-		p.positioner = new(slotsPosition)
-	} else {
-		//p.positioner = &p.ringEntryPosition
-		p.positioner = new(ringEntryPosition)
-	}
-	err = p.positioner.InitPosition(p.buffer, p.index, cap, entrySize)
-	fmt.Println(p)
+	err = p.positioner.InitPosition(p.buffer, p.index, cap, p.entrySize)
+	debug("%s\n", p)
 	p.showEntrys()
 	return p, err
 }
 
+//size class pool's poolId has class bits
+func (p *Pool) isValuePool() bool {
+	return p.index>>classShift == 0
+}
+
 func (p *Pool) Cap() uint32 {
 	return p.size
 }
 
 func (p *Pool) invalid(id uint32) bool {
-	if uint32(len(p.buffer)) > id {
+	if p.size > id {
 		return false
 	}
 	return true
 }
 
+//byte offset of entry in buffer, entryId is the index of entry
+func (p *Pool) entryOffset(id uint32) int {
+	return int(id) * p.entrySize
+}
+
+func (p *Pool) entryAt(id uint32) *Entry {
+	return (*Entry)(unsafe.Pointer(&p.buffer[p.entryOffset(id)]))
+}
+
 func (p *Pool) String() string {
-	return fmt.Sprintf("pool:index=%d, size=%d, entrySize=%d, bufferSize=%d, useSlots=%v", p.index, p.size, entrySize, len(p.buffer), p.useSlots)
+	return fmt.Sprintf("pool:index=%d, size=%d, entrySize=%d, bufferSize=%d, positioner=%s", p.index, p.size, p.entrySize, len(p.buffer), p.kind)
 }
 
 func (p *Pool) showEntrys() {
+	if !logEnabled(LogDebug, GetLogLevel()) {
+		return
+	}
 	for i := 0; i < int(p.size); i++ {
-		e := (*EntryHeader)(unsafe.Pointer(&p.buffer[i*entrySize]))
-		fmt.Printf("i:=%d, %s\n", i, e)
+		e := p.entryAt(uint32(i))
+		debug("i:=%d, %s\n", i, &e.EntryHeader)
 	}
 }
 
@@ -311,116 +509,205 @@ func GetEntryFromElem(v *Value) *Entry {
 
 func GetElemID(v *Value) uint64 {
 	e := GetEntryFromElem(v)
-	return *(*uint64)(unsafe.Pointer(&e.EntryHeader))
+	return e.elemID()
+}
+
+//elemID is the entryPosition of entry, it is stored in poolShardMap
+func (e *EntryHeader) elemID() uint64 {
+	return *(*uint64)(unsafe.Pointer(e))
 }
 
-func (cp *cachePool) PutValue(v *Value) {
+//PutValue put v back to its pool, v must be got from GetValue, or ErrForeignPointer,
+//and must not be put back twice, or ErrDoubleFree. ErrPutFailed means its pool is broken, v is lost
+func (cp *cachePool) PutValue(v *Value) error {
 	if v == nil {
-		return
+		return nil
+	}
+	if cp.findPool(v) == nil {
+		atomic.AddUint64(&cp.foreignPuts, 1)
+		return ErrForeignPointer
 	}
 	e := GetEntryFromElem(v)
-	cp.PutEntry(e)
+	if !e.isUsed() {
+		atomic.AddUint64(&cp.doubleFrees, 1)
+		return ErrDoubleFree
+	}
+	//ErrDoubleFree if it is put back by others at the same time
+	return cp.putEntry(e)
+}
+
+//findPool return the pool whose buffer contains v at an entry boundary, nil if
+//v is not from any pool. it only compares address, never dereference v
+func (cp *cachePool) findPool(v *Value) *Pool {
+	p, off := cp.poolOfAddr(uintptr(unsafe.Pointer(v)) - offset)
+	if p == nil || !p.isValuePool() || off%uintptr(p.entrySize) != 0 {
+		return nil
+	}
+	return p
+}
+
+//BadPuts return the num of PutValue rejected by ErrDoubleFree and ErrForeignPointer
+func (cp *cachePool) BadPuts() (doubleFrees, foreignPuts uint64) {
+	return atomic.LoadUint64(&cp.doubleFrees), atomic.LoadUint64(&cp.foreignPuts)
 }
 
 //here Entry is pool buffer'Entry
 func (cp *cachePool) PutEntry(e *Entry) bool {
-	index := int(e.poolId)
-	if index >= len(cp.pools) {
-		return false
+	return cp.putEntry(e) == nil
+}
+
+func (cp *cachePool) putEntry(e *Entry) error {
+	if cp.getPool(e.poolId) == nil {
+		return ErrForeignPointer
 	}
 	//todo: 如果使用率过少，可以不用put 回去，当这个pool 使用率为0时，可以清除pool，让gc 回收
 
+	//entry is leased by Acquire, the last release will free it
+	if deferred, err := cp.deferFree(e); deferred || err != nil {
+		return err
+	}
+	return cp.freeEntry(e)
+}
+
+//free e and put it back, ErrPutFailed means the UsedFlag is cleaned but e is lost
+func (cp *cachePool) freeEntry(e *Entry) error {
 	//clean UsedFlag even if put fail
 	//e.nextFree &= (UsedFlag - 1)
 
 	//check if this entry have been put back, avoid doing PutEntry twice
-	//do it lockless
-	for {
-		flag := atomic.LoadUint32(&e.nextFree)
-		if flag&UsedFlag == 0 { //have clean UsedFlag, means it has been put back to pool
-			break
-		}
-		//clean UsedFlag
-		newflag := flag & (UsedFlag - 1)
-		if atomic.CompareAndSwapUint32(&e.nextFree, flag, newflag) {
-			break
+	if !e.clearUsed() {
+		atomic.AddUint64(&cp.doubleFrees, 1)
+		return ErrDoubleFree
+	}
+	atomic.AddUint32(&e.gen, 1)
+	p := cp.getPool(e.poolId)
+	if !p.isDraining() {
+		if cp.waitq.handoff(e) {
+			return nil
 		}
 	}
-	return cp.pools[index].PutEntry(e)
+	if debugBuild {
+		debugPoison(p, e)
+	}
+	if !p.isDraining() && cp.putLocalEntry(e) {
+		cp.handoffLocal()
+		return nil
+	}
+	if !p.PutEntry(e) {
+		return ErrPutFailed
+	}
+	cp.handoffPool(p)
+	return nil
 }
 
 //Delete Value --> buffer entry --> putEntry()
 func (p *Pool) PutEntry(e *Entry) bool {
-	return p.positioner.PutEntryHeader(&e.EntryHeader)
+	if !p.positioner.PutEntryHeader(&e.EntryHeader) {
+		return false
+	}
+	p.addUsed(-1)
+	return true
 }
 
 func (cp *cachePool) GetValue() *Value {
+	if e := cp.getLocalEntry(); e != nil {
+		return &e.Value
+	}
+	return cp.getValue(0, false)
+}
+
+//probe pools from the one chosen by PoolSelector, extend cachePool if all pools are full
+//hint is the key's hash if hasKey, see PoolSelector
+func (cp *cachePool) getValue(hint uint64, hasKey bool) *Value {
 	var p *Pool
-	poolNum := 0
-	start := getPid()
 	for {
-		pools := cp.pools
+		t := cp.table.Load()
+		pools := t.pools
 		max := len(pools)
-		poolNum = cp.GetPoolNum()
+		start := cp.selectPool(pools, hint, hasKey)
 		for n := 0; n < max; n++ {
-			start++
-			p = pools[start%max]
+			p = pools[(start+n)%max]
 			if p == nil {
 				continue
 			}
 
 			entry := p.GetEntry()
-			fmt.Printf("process id:%d\n", start)
+			cp.debug("process id:%d\n", start)
 			if entry != nil {
 				//return entry
 				return &entry.Value
 			}
 		}
-		if !cp.autoExtend {
+		if e := cp.stealLocal(); e != nil {
+			return &e.Value
+		}
+		l := cp.live()
+		if !l.autoExtend {
 			return nil
 		}
 		var err error
 		cp.Lock()
-		if poolNum < cp.GetPoolNum() { //have apppend new pool
+		if t != cp.table.Load() { //have apppend new pool or Compact
 			//start = len(cp.pools) - 1 //so start from last pool
 			cp.Unlock()
 			continue
 		}
-		if cp.maxPool != 0 && cp.GetPoolNum() >= cp.maxPool {
+		if l.maxPool != 0 && cp.GetPoolNum() >= l.maxPool {
 			//log
-			fmt.Printf("have touch top, cp.maxPool:%d", cp.maxPool)
+			cp.notice("have touch top, cp.maxPool:%d\n", l.maxPool)
+			cp.Unlock()
+			return nil
+		}
+		cap := cp.nextPoolCap(l, cp.loadPools(), cp.poolCap)
+		if cap > 0 {
+			cap = cp.reserveMemory(l, cap, entrySize, true)
+		}
+		if cap <= 0 {
+			cp.notice("growth policy or memory limit stop extending, capacity:%d, memory:%d\n", cp.Capacity(), cp.MemoryUsage())
 			cp.Unlock()
 			return nil
 		}
-		p, err = cp.NewPool()
+		p, err = cp.addPool(cap)
 		if err != nil {
+			cp.releaseMemory(cap, entrySize)
 			cp.Unlock()
 			return nil
 		}
 		entry := p.GetEntry()
 		cp.Unlock()
 		//log
-		fmt.Printf("add new pool,now cp:%s\n", cp)
+		cp.info("add new pool,now cp:%s\n", cp)
 		if entry == nil {
-			panic("new pool, get entry must be successfull")
+			//new pool is published before GetEntry, other goroutines may take all its entries
+			continue
 		}
 		return &entry.Value
 	}
-	return nil
 }
 
 func (p *Pool) GetEntry() *Entry {
+	if p.isDraining() {
+		return nil
+	}
 	eh := p.positioner.GetEntryHeader()
 	if p.invalid(eh.entryId) {
 		//log
 		return nil
 	}
-	entry := (*Entry)(unsafe.Pointer(&p.buffer[int(eh.entryId)]))
-	fmt.Println("GetValue:", entry)
+	p.addUsed(1)
+	if p.putBackIfDraining([]EntryHeader{eh}) {
+		return nil
+	}
+	entry := p.entryAt(eh.entryId)
+	debug("GetValue:%s\n", entry)
 	if entry.isUsed() {
 		panic("GetEntry: entry have been used?")
 	}
 	entry.nextFree |= UsedFlag //means this entry of buffer has been used
+	if debugBuild && p.isValuePool() {
+		debugCheckAlloc(p, entry)
+	}
+	p.trackAlloc(entry)
 	return entry
 }
 
@@ -435,27 +722,20 @@ func NewShardMap(n int) (*poolShardMap, error) {
 	sm.origSize = n
 	sm.shardSize = CeilToPowerOfTwo(n)
 	sm.shardMask = sm.shardSize - 1
-	sm.maps = make([]map[Key]uint64, sm.shardSize)
-	for i, _ := range sm.maps {
-		sm.maps[i] = make(map[Key]uint64)
-	}
+	sm.maps = makeShards(sm.shardSize)
 	return sm, nil
 }
 
 func (cp *cachePool) Store(key Key, v *Value) {
-	hash := key.Hash()
-	m := cp.sm.maps[hash&cp.sm.shardMask]
 	elemID := GetElemID(v)
 	cp.sm.Lock()
-	m[key] = elemID
+	cp.sm.set(&key, elemID)
 	cp.sm.Unlock()
 }
 
 func (cp *cachePool) Load(key Key) *Value {
-	hash := key.Hash()
-	m := cp.sm.maps[hash&cp.sm.shardMask]
 	cp.sm.RLock()
-	elemID, ok := m[key]
+	elemID, ok := cp.sm.get(&key)
 	cp.sm.RUnlock()
 	if !ok {
 		return nil
@@ -464,22 +744,21 @@ func (cp *cachePool) Load(key Key) *Value {
 }
 
 func (cp *cachePool) Delete(key Key) {
-	hash := key.Hash()
-	m := cp.sm.maps[hash&cp.sm.shardMask]
 	cp.sm.Lock()
-	delete(m, key)
+	cp.sm.del(&key)
 	cp.sm.Unlock()
 	return
 
 }
 
+//DeleteAndFreeValue delete key and put its Value back, it return false and keep key
+//if key is not exist or it is stored by StoreBytes, see DeleteAndFreeBytes
 func (cp *cachePool) DeleteAndFreeValue(key Key) bool {
-	hash := key.Hash()
-	m := cp.sm.maps[hash&cp.sm.shardMask]
 	cp.sm.Lock()
-	elemID, ok := m[key]
+	elemID, ok := cp.sm.get(&key)
+	ok = ok && !isBytesElemID(elemID)
 	if ok {
-		delete(m, key)
+		cp.sm.del(&key)
 	}
 	cp.sm.Unlock()
 	if !ok {
@@ -502,16 +781,16 @@ func (cp *cachePool) getValueFromElemID(elemID uint64) *Value {
 
 func (cp *cachePool) getEntryFromElemID(elemID uint64) *Entry {
 	entryh := (*EntryHeader)(unsafe.Pointer(&elemID))
-	if int(entryh.poolId) >= len(cp.pools) {
-		// log
+	p := cp.getPool(entryh.poolId)
+	if p == nil {
+		//not exist or released by Compact
 		return nil
 	}
-	pbuf := cp.pools[entryh.poolId].buffer
-	if int(entryh.entryId) >= len(pbuf) {
+	if p.invalid(entryh.entryId) {
 		// log
 		return nil
 	}
-	return (*Entry)(unsafe.Pointer(&pbuf[entryh.entryId]))
+	return p.entryAt(entryh.entryId)
 }
 
 /*
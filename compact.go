@@ -0,0 +1,227 @@
+package cachePool
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+compaction: 突发流量过后，存活的entry 分散在很多使用率很低的pool 里，这些pool 都不能释放。
+Compact 把稀疏pool (source) 里被Store 到shardMap 的entry 移到稠密pool 的空闲entry 里，
+然后释放已经空了的source pool, 让gc 回收buffer.
+
+	1. 先把per-P magazine flush 回pool, 使pool 的used 计数是准确的
+	2. 按used 从小到大选source pool, 保证其余pool 的空闲entry 够放下所有source 的存活entry
+	3. source pool 标记为draining, 不再分配entry, 释放的entry 也直接回到pool, 不进magazine/等待队列
+	4. 在shardMap 写锁下移动entry: copy value, 把所有指向它的key 改成新的elemID, 旧entry 的generation+1
+	5. 空了的source pool 从pool table 里删除(下标不再复用, 旧的Handle/elemID 不会指向新pool)，没空的恢复分配
+
+不能移动的entry: 被Acquire 租用的、被LockValue 锁住的、没有Store 到shardMap 的(只有用户自己持有指针)，
+这些entry 所在的pool 就不会被释放。
+移动之后, 用户之前Load 拿到的*Value 就是旧的了，用Generation/Stale 检查，重新Load.
+只compact Value pools, size class pools 不处理。
+*/
+
+//CompactStats is the result of Compact
+type CompactStats struct {
+	Moved    int //entries moved
+	Skipped  int //entries can't be moved
+	Released int //pools released
+}
+
+//Generation return the generation of v, it is changed when v is freed or moved by Compact
+func Generation(v *Value) uint32 {
+	return atomic.LoadUint32(&GetEntryFromElem(v).gen)
+}
+
+//Stale return true if v have been freed or moved by Compact since Generation return gen,
+//then v should be Load again
+func Stale(v *Value, gen uint32) bool {
+	return Generation(v) != gen
+}
+
+func (p *Pool) isDraining() bool {
+	return atomic.LoadUint32(&p.draining) != 0
+}
+
+//the pool may start draining after the getter check isDraining, and be released
+//if its used is 0, so the getter check again after used is increased: if it is
+//draining now, put the entries back, or Compact must see the used
+func (p *Pool) putBackIfDraining(ehs []EntryHeader) bool {
+	if len(ehs) == 0 || !p.isDraining() {
+		return false
+	}
+	var bufs [batchSize]*EntryHeader
+	for i := range ehs {
+		bufs[i] = &p.entryAt(ehs[i].entryId).EntryHeader
+	}
+	put := p.positioner.PutEntryHeaders(bufs[:len(ehs)])
+	p.addUsed(-put)
+	return true
+}
+
+//flush all per-P magazines back to pools
+func (cp *cachePool) flushLocals() {
+	for i := range cp.locals {
+		l := &cp.locals[i]
+		for !l.tryLock() {
+			spinYield()
+		}
+		cp.flushLocal(l, l.n)
+		l.unlock()
+	}
+}
+
+//pick sparse pools whose live entries can be put into the free entries of the others,
+//at least one pool is kept
+func (cp *cachePool) compactSources() []*Pool {
+	var pools []*Pool
+	room := 0
+	for _, p := range cp.loadPools() {
+		if p != nil {
+			pools = append(pools, p)
+			room += int(p.Cap()) - int(p.Used())
+		}
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		return pools[i].Used() < pools[j].Used()
+	})
+	need := 0
+	n := 0
+	for ; n < len(pools)-1; n++ {
+		p := pools[n]
+		used := int(p.Used())
+		//p's free entries are not room any more, and its used entries need room
+		if need+used > room-(int(p.Cap())-used) {
+			break
+		}
+		need += used
+		room -= int(p.Cap()) - used
+	}
+	return pools[:n]
+}
+
+//Compact move live entries out of sparse pools and release the pools emptied,
+//it stop and return ctx.Err() when ctx is done, the pools emptied are released still
+func (cp *cachePool) Compact(ctx context.Context) (CompactStats, error) {
+	var stats CompactStats
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+	cp.Lock() //no extension during compaction
+	defer cp.Unlock()
+
+	cp.flushLocals()
+	sources := cp.compactSources()
+	if len(sources) == 0 {
+		return stats, nil
+	}
+	isSource := make(map[uint32]bool, len(sources))
+	for _, p := range sources {
+		atomic.StoreUint32(&p.draining, 1)
+		isSource[uint32(p.index)] = true
+	}
+	//entries of sources may be put to magazines before draining
+	cp.flushLocals()
+
+	//elemID -> keys
+	refs := make(map[uint64][]Key)
+	cp.sm.RLock()
+	cp.sm.rangeAll(func(k Key, elemID uint64) {
+		if isSource[(*EntryHeader)(unsafe.Pointer(&elemID)).poolId] {
+			refs[elemID] = append(refs[elemID], k)
+		}
+	})
+	cp.sm.RUnlock()
+
+	var err error
+	for elemID, keys := range refs {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		moved, full := cp.moveEntry(elemID, keys)
+		if full {
+			break
+		}
+		if moved {
+			stats.Moved++
+		} else {
+			stats.Skipped++
+		}
+	}
+
+	cp.flushLocals()
+	for _, p := range sources {
+		if p.Used() != 0 {
+			atomic.StoreUint32(&p.draining, 0)
+			continue
+		}
+		cp.setPool(p.index, nil)
+		cp.releaseMemory(int(p.Cap()), p.entrySize)
+		stats.Released++
+	}
+	cp.info("compact: moved:%d, skipped:%d, released:%d, now cp:%s\n", stats.Moved, stats.Skipped, stats.Released, cp)
+	return stats, err
+}
+
+//get a free entry from the pools which are not draining
+func (cp *cachePool) compactTarget() *Entry {
+	for _, p := range cp.loadPools() {
+		if p == nil || p.isDraining() {
+			continue
+		}
+		if e := p.GetEntry(); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+//move the entry of elemID to a dense pool and point keys to it, full is true if no free entry
+func (cp *cachePool) moveEntry(elemID uint64, keys []Key) (moved, full bool) {
+	e := cp.getEntryFromElemID(elemID)
+	if e == nil {
+		return false, false
+	}
+	cp.sm.Lock()
+	defer cp.sm.Unlock()
+	//keys may be deleted or stored again since they are collected
+	n := 0
+	for _, k := range keys {
+		if id, ok := cp.sm.get(&k); ok && id == elemID {
+			keys[n] = k
+			n++
+		}
+	}
+	keys = keys[:n]
+	if len(keys) == 0 || !e.isUsed() {
+		return false, false
+	}
+	//not leased and not locked, no Acquire can lease it under sm.Lock, but LockValue by the pointer of Load still can
+	if !atomic.CompareAndSwapUint32(&e.state, 0, entryWriterBit) {
+		return false, false
+	}
+	ne := cp.compactTarget()
+	if ne == nil {
+		atomic.StoreUint32(&e.state, 0)
+		return false, true
+	}
+	val := loadValue(&e.Value)
+	storeValue(&ne.Value, &val)
+	cp.moveLeakRecord(e, ne)
+	for i := range keys {
+		cp.sm.set(&keys[i], ne.elemID())
+	}
+	atomic.AddUint32(&e.gen, 1)
+	atomic.StoreUint32(&e.state, 0)
+
+	//pool is draining, put it back to pool directly
+	e.clearUsed()
+	if debugBuild {
+		debugPoison(cp.getPool(e.poolId), e)
+	}
+	cp.getPool(e.poolId).PutEntry(e)
+	return true, false
+}
@@ -0,0 +1,232 @@
+package cachePool
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+declarative config: 服务一般从配置文件加载缓存的参数，Config 是导出的、带json/yaml tag 的配置，
+NewFromConfig 把它翻译成Option 再调用NewCachePool, 所以和用Option 创建的cachePool 完全一样。
+零值的字段用默认值:
+	autoExtend 不填是true
+	shardSize 不填是min(poolNum, 16)
+	positioner 不填由flag useSlots 决定(slots 或 ring)
+	growth.policy 不填是fixed, 每次加一个poolCap 大小的pool
+	logLevel 不填是default, 跟随全局的SetLogLevel (默认info)，填了只影响这个cachePool
+Validate 检查所有字段，返回ConfigErrors, 每个FieldError 带字段名(json 名字)和原因。
+*/
+
+//Config is the declarative config of cachePool, see NewFromConfig
+type Config struct {
+	PoolNum    int   `json:"poolNum" yaml:"poolNum"`                           //initial Value pool num
+	PoolCap    int   `json:"poolCap" yaml:"poolCap"`                           //entry num of initial Value pools
+	AutoExtend *bool `json:"autoExtend,omitempty" yaml:"autoExtend,omitempty"` //nil means true
+
+	MaxPool     int          `json:"maxPool,omitempty" yaml:"maxPool,omitempty"`         //0 means no limit
+	MaxCapacity int          `json:"maxCapacity,omitempty" yaml:"maxCapacity,omitempty"` //0 means no limit
+	MemoryLimit int64        `json:"memoryLimit,omitempty" yaml:"memoryLimit,omitempty"` //bytes, 0 means no limit, also the GOMEMLIMIT check
+	Growth      GrowthConfig `json:"growth,omitempty" yaml:"growth,omitempty"`
+
+	ShardSize      int    `json:"shardSize,omitempty" yaml:"shardSize,omitempty"`
+	Positioner     string `json:"positioner,omitempty" yaml:"positioner,omitempty"` //default, slots, ring, lfslots, bitmap
+	LocalCacheSize int    `json:"localCacheSize,omitempty" yaml:"localCacheSize,omitempty"`
+	NUMA           bool   `json:"numa,omitempty" yaml:"numa,omitempty"`
+
+	SizeClasses SizeClassConfig `json:"sizeClasses,omitempty" yaml:"sizeClasses,omitempty"`
+
+	LeakTracking bool   `json:"leakTracking,omitempty" yaml:"leakTracking,omitempty"`
+	LogLevel     string `json:"logLevel,omitempty" yaml:"logLevel,omitempty"` //default, debug, info, notice, warn, off
+}
+
+//GrowthConfig chose the GrowthPolicy
+type GrowthConfig struct {
+	Policy string  `json:"policy,omitempty" yaml:"policy,omitempty"` //fixed, geometric, targetUtil
+	Step   int     `json:"step,omitempty" yaml:"step,omitempty"`     //fixed: entries of new pool, 0 means poolCap
+	Factor float64 `json:"factor,omitempty" yaml:"factor,omitempty"` //geometric: e.g. 1.25
+	Target float64 `json:"target,omitempty" yaml:"target,omitempty"` //targetUtil: e.g. 0.8
+}
+
+//SizeClassConfig is the size class pools for GetBytes, Max 0 means disable
+type SizeClassConfig struct {
+	Min     int `json:"min,omitempty" yaml:"min,omitempty"`
+	Max     int `json:"max,omitempty" yaml:"max,omitempty"`
+	PoolCap int `json:"poolCap,omitempty" yaml:"poolCap,omitempty"`
+}
+
+const (
+	GrowthFixed      = "fixed"
+	GrowthGeometric  = "geometric"
+	GrowthTargetUtil = "targetUtil"
+)
+
+//FieldError is the error of a field of Config, Field is the json name, e.g. growth.factor
+type FieldError struct {
+	Field  string
+	Value  interface{}
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s=%v: %s", e.Field, e.Value, e.Reason)
+}
+
+//ConfigErrors is all FieldError of a Config
+type ConfigErrors []*FieldError
+
+func (es ConfigErrors) Error() string {
+	s := make([]string, len(es))
+	for i, e := range es {
+		s[i] = e.Error()
+	}
+	return "invalid config: " + strings.Join(s, "; ")
+}
+
+//ParsePositionerKind parse the name return by PositionerKind.String(), "" is PositionerDefault
+func ParsePositionerKind(s string) (PositionerKind, error) {
+	for k := PositionerDefault; k <= PositionerBitmap; k++ {
+		if strings.EqualFold(s, k.String()) {
+			return k, nil
+		}
+	}
+	if s == "" {
+		return PositionerDefault, nil
+	}
+	return PositionerDefault, fmt.Errorf("unknown positioner:%q", s)
+}
+
+//GrowthPolicy return the policy of g, nil means the default FixedStepGrowth{}
+func (g GrowthConfig) GrowthPolicy() (GrowthPolicy, error) {
+	switch g.Policy {
+	case "", GrowthFixed:
+		if g.Step < 0 || g.Step > MaxPoolSize {
+			return nil, &FieldError{"growth.step", g.Step, fmt.Sprintf("out of range [0, %d]", MaxPoolSize)}
+		}
+		if g.Step == 0 {
+			return nil, nil
+		}
+		return FixedStepGrowth{Step: g.Step}, nil
+	case GrowthGeometric:
+		if !(g.Factor > 1) {
+			return nil, &FieldError{"growth.factor", g.Factor, "must be > 1"}
+		}
+		return GeometricGrowth{Factor: g.Factor}, nil
+	case GrowthTargetUtil:
+		if !(g.Target > 0 && g.Target <= 1) {
+			return nil, &FieldError{"growth.target", g.Target, "must be in (0, 1]"}
+		}
+		return TargetUtilGrowth{Target: g.Target}, nil
+	}
+	return nil, &FieldError{"growth.policy", g.Policy, "must be fixed, geometric or targetUtil"}
+}
+
+func (c *Config) autoExtend() bool {
+	return c.AutoExtend == nil || *c.AutoExtend
+}
+
+func (c *Config) shardSize() int {
+	if c.ShardSize == 0 {
+		return defaultShardSize(c.PoolNum)
+	}
+	return c.ShardSize
+}
+
+//Validate check every field, it return ConfigErrors or nil
+func (c *Config) Validate() error {
+	var es ConfigErrors
+	add := func(field string, v interface{}, format string, a ...interface{}) {
+		es = append(es, &FieldError{Field: field, Value: v, Reason: fmt.Sprintf(format, a...)})
+	}
+
+	kind, err := ParsePositionerKind(c.Positioner)
+	if err != nil {
+		add("positioner", c.Positioner, "must be default, slots, ring, lfslots or bitmap")
+	}
+	ring := err == nil && resolvePositioner(kind) == PositionerRing
+
+	if c.PoolNum < 0 || c.PoolNum > MaxPoolNum {
+		add("poolNum", c.PoolNum, "out of range [0, %d]", MaxPoolNum)
+	}
+	if reason := poolCapLimit(c.PoolCap, entrySize); reason != "" {
+		add("poolCap", c.PoolCap, "%s", reason)
+	} else if ring && !IsPowerOfTwo(c.PoolCap) {
+		add("poolCap", c.PoolCap, "must be power of two for ring positioner")
+	}
+	if c.MaxPool < 0 || c.MaxPool > MaxPoolNum {
+		add("maxPool", c.MaxPool, "out of range [0, %d], 0 means no limit", MaxPoolNum)
+	} else if c.MaxPool != 0 && c.PoolNum > c.MaxPool {
+		add("maxPool", c.MaxPool, "less than poolNum:%d", c.PoolNum)
+	}
+	if c.MaxCapacity < 0 {
+		add("maxCapacity", c.MaxCapacity, "must be >= 0, 0 means no limit")
+	}
+	if c.MemoryLimit < 0 {
+		add("memoryLimit", c.MemoryLimit, "must be >= 0, 0 means no limit")
+	}
+	if _, err := c.Growth.GrowthPolicy(); err != nil {
+		es = append(es, err.(*FieldError))
+	}
+
+	if c.ShardSize < 0 || c.ShardSize > MaxShardSize {
+		add("shardSize", c.ShardSize, "out of range [0, %d], 0 means min(poolNum, 16)", MaxShardSize)
+	} else if c.ShardSize == 0 && c.PoolNum == 0 {
+		add("shardSize", c.ShardSize, "must be set when poolNum is 0")
+	}
+	if c.LocalCacheSize < 0 {
+		add("localCacheSize", c.LocalCacheSize, "must be >= 0, 0 means disable")
+	}
+
+	if sc := c.SizeClasses; sc.Max != 0 || sc.Min != 0 || sc.PoolCap != 0 {
+		if sc.Max <= 0 || sc.Max > maxClassSize {
+			add("sizeClasses.max", sc.Max, "out of range [1, %d]", maxClassSize)
+		} else if sc.Min <= 0 || sc.Min > sc.Max {
+			add("sizeClasses.min", sc.Min, "out of range [1, max:%d]", sc.Max)
+		} else if reason := poolCapLimit(sc.PoolCap, classEntrySize(CeilToPowerOfTwo(sc.Max))); reason != "" {
+			add("sizeClasses.poolCap", sc.PoolCap, "%s", reason)
+		} else if ring && !IsPowerOfTwo(sc.PoolCap) {
+			add("sizeClasses.poolCap", sc.PoolCap, "must be power of two for ring positioner")
+		}
+	}
+
+	if _, err := ParseLogLevel(c.LogLevel); err != nil {
+		add("logLevel", c.LogLevel, "must be default, debug, info, notice, warn or off")
+	}
+	if len(es) != 0 {
+		return es
+	}
+	return nil
+}
+
+//Options translate c to Options of NewCachePool, c must be valid
+func (c *Config) Options() []Option {
+	kind, _ := ParsePositionerKind(c.Positioner)
+	growth, _ := c.Growth.GrowthPolicy()
+	level, _ := ParseLogLevel(c.LogLevel)
+	opts := []Option{
+		OptionWithAutoExtend(c.autoExtend()),
+		OptionWithMaxPool(c.MaxPool),
+		OptionWithMaxCapacity(c.MaxCapacity),
+		OptionWithMemoryLimit(c.MemoryLimit),
+		OptionWithGrowthPolicy(growth),
+		OptionWithPositioner(kind),
+		OptionWithLocalCache(c.LocalCacheSize),
+		OptionWithNUMA(c.NUMA),
+		OptionWithLeakTracking(c.LeakTracking),
+		OptionWithLogLevel(level),
+	}
+	if c.ShardSize != 0 {
+		opts = append(opts, OptionWithShardSize(c.ShardSize))
+	}
+	if sc := c.SizeClasses; sc.Max != 0 {
+		opts = append(opts, OptionWithSizeClasses(sc.Min, sc.Max, sc.PoolCap))
+	}
+	return opts
+}
+
+//NewFromConfig validate cfg and create the cachePool
+func NewFromConfig(cfg Config) (*cachePool, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return NewCachePool(cfg.PoolNum, cfg.PoolCap, cfg.Options()...)
+}
@@ -0,0 +1,20 @@
+//go:build !cachepool_debug
+// +build !cachepool_debug
+
+package cachePool
+
+//no poisoning and alloc record, see debug_on.go
+const debugBuild = false
+
+type poolDebug struct{}
+
+func debugPoisonPool(p *Pool) {}
+
+func debugPoison(p *Pool, e *Entry) {}
+
+func debugCheckAlloc(p *Pool, e *Entry) {}
+
+//AllocStack return the stack of the last allocation of v, only in cachepool_debug build
+func (cp *cachePool) AllocStack(v *Value) string {
+	return ""
+}
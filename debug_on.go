@@ -0,0 +1,99 @@
+//go:build cachepool_debug
+// +build cachepool_debug
+
+package cachePool
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+/*
+debug build: go build -tags cachepool_debug
+1. value 被put 回pool 时，用poisonByte 填充，再次分配时检查是否还是poisonByte，
+   不是的话，说明有人在free 之后还在写这个value (use after free)
+2. 记录每个entry 最后一次分配和释放的goroutine/stack，发现问题时打印出来,
+   记录放在Pool.debug 里, 按entryId 索引, pool 被Compact 释放时一起释放
+*/
+const debugBuild = true
+
+const poisonByte = 0xa5
+
+type allocRecord struct {
+	allocStack string
+	freeStack  string
+}
+
+type poolDebug struct {
+	sync.Mutex
+	records []allocRecord //index is entryId
+}
+
+func callerStack() string {
+	buf := make([]byte, 4096)
+	return string(buf[:runtime.Stack(buf, false)])
+}
+
+func valueBytes(e *Entry) []byte {
+	return (*[unsafe.Sizeof(Value{})]byte)(unsafe.Pointer(&e.Value))[:]
+}
+
+func debugRecord(p *Pool, e *Entry, alloc bool) {
+	p.debug.Lock()
+	defer p.debug.Unlock()
+	r := &p.debug.records[e.entryId]
+	if alloc {
+		r.allocStack = callerStack()
+	} else {
+		r.freeStack = callerStack()
+	}
+}
+
+//poison all values of a new pool, so debugCheckAlloc can check them
+func debugPoisonPool(p *Pool) {
+	p.debug.records = make([]allocRecord, p.size)
+	for i := 0; i < int(p.size); i++ {
+		e := p.entryAt(uint32(i))
+		b := valueBytes(e)
+		for j := range b {
+			b[j] = poisonByte
+		}
+	}
+}
+
+//poison the value which is put back
+func debugPoison(p *Pool, e *Entry) {
+	debugRecord(p, e, false)
+	b := valueBytes(e)
+	for i := range b {
+		b[i] = poisonByte
+	}
+}
+
+//check the value is still poisoned when it is allocated again
+func debugCheckAlloc(p *Pool, e *Entry) {
+	b := valueBytes(e)
+	for i := range b {
+		if b[i] != poisonByte {
+			p.debug.Lock()
+			r := p.debug.records[e.entryId]
+			p.debug.Unlock()
+			panic(fmt.Sprintf("cachePool: %s is written after free, byte %d is %#x\n", e, i, b[i]) +
+				"last alloc at:\n" + r.allocStack + "\nlast free at:\n" + r.freeStack)
+		}
+	}
+	debugRecord(p, e, true)
+}
+
+//AllocStack return the stack of the last allocation of v, only in cachepool_debug build
+func (cp *cachePool) AllocStack(v *Value) string {
+	p := cp.findPool(v)
+	if p == nil {
+		return ""
+	}
+	p.debug.Lock()
+	defer p.debug.Unlock()
+	return p.debug.records[GetEntryFromElem(v).entryId].allocStack
+}
@@ -0,0 +1,101 @@
+package cachePool
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+/*
+per-entry read write spinlock, 用Entry.state 的低16 位实现(高16 位是lease, 见lease.go)，
+两个goroutine Load 到同一个key 的value，原地修改value 时需要加锁保护。
+
+跟SpinLock 不同，这里不能procPin(), 因为持有锁期间执行的是用户的代码，可能会阻塞，
+所以像allenxuxu 的spinlock 那样，自旋几次拿不到锁就runtime.Gosched()
+*/
+const (
+	entryWriterBit  = 1 << 15
+	entryReaderMask = entryWriterBit - 1
+	entryLockMask   = entryWriterBit | entryReaderMask
+	entryActiveSpin = 16
+)
+
+func spinWait(i int) {
+	if i >= entryActiveSpin {
+		runtime.Gosched()
+	}
+}
+
+//LockValue lock v for writing
+func (cp *cachePool) LockValue(v *Value) {
+	e := GetEntryFromElem(v)
+	for i := 0; !e.tryLock(); i++ {
+		spinWait(i)
+	}
+}
+
+func (e *Entry) tryLock() bool {
+	s := atomic.LoadUint32(&e.state)
+	return s&entryLockMask == 0 && atomic.CompareAndSwapUint32(&e.state, s, s|entryWriterBit)
+}
+
+func (e *Entry) unlock() bool {
+	for {
+		s := atomic.LoadUint32(&e.state)
+		if s&entryLockMask != entryWriterBit {
+			return false
+		}
+		if atomic.CompareAndSwapUint32(&e.state, s, s&^entryWriterBit) {
+			return true
+		}
+	}
+}
+
+//UnlockValue must be after LockValue, or will panic
+func (cp *cachePool) UnlockValue(v *Value) {
+	e := GetEntryFromElem(v)
+	if !e.unlock() {
+		panic("entry unlock fail")
+	}
+}
+
+//RLockValue lock v for reading, many readers can hold it at the same time
+func (cp *cachePool) RLockValue(v *Value) {
+	e := GetEntryFromElem(v)
+	for i := 0; ; i++ {
+		s := atomic.LoadUint32(&e.state)
+		//reader count can't carry into the writer bit
+		if s&entryWriterBit == 0 && s&entryReaderMask != entryReaderMask &&
+			atomic.CompareAndSwapUint32(&e.state, s, s+1) {
+			return
+		}
+		spinWait(i)
+	}
+}
+
+//RUnlockValue must be after RLockValue, or will panic
+func (cp *cachePool) RUnlockValue(v *Value) {
+	e := GetEntryFromElem(v)
+	for {
+		s := atomic.LoadUint32(&e.state)
+		if s&entryWriterBit != 0 || s&entryReaderMask == 0 {
+			panic("entry runlock fail")
+		}
+		if atomic.CompareAndSwapUint32(&e.state, s, s-1) {
+			return
+		}
+	}
+}
+
+//Update run f with the value of key locked, the value is leased during f, so it
+//will not be freed. return false if key is not exist, the lock and lease are released even if f panic
+func (cp *cachePool) Update(key Key, f func(*Value)) bool {
+	v, release := cp.Acquire(key)
+	if v == nil {
+		return false
+	}
+	defer release()
+	cp.LockValue(v)
+	defer cp.UnlockValue(v)
+	f(v)
+	return true
+}
@@ -14,7 +14,7 @@ func main() {
 		fmt.Println(err)
 		return
 	}
-	key := cachePool.Key{1, 2, 3}
+	key := cachePool.Key{A: 1, B: 2, C: 3}
 	v := cp.GetValue()
 	if v == nil {
 		panic("v is nil")
@@ -0,0 +1,3 @@
+module github.com/jursonmo/cachePool
+
+go 1.21
@@ -0,0 +1,194 @@
+package cachePool
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+/*
+growth policy: pool buffer 不能原地扩容(value 的地址已经给出去了，realloc 会让这些地址失效)，
+所以扩展仍然是新建一个pool, 但新pool 的cap 由GrowthPolicy 决定，不再固定是poolCap,
+这样只需要多10% 容量时不用每次多出100%。pools 的cap 可以不一样，ring 的cap 会向上取2的幂。
+*/
+
+//GrowthState is what GrowthPolicy see when all pools are full
+type GrowthState struct {
+	PoolCap  int //poolCap of NewCachePool or classPoolCap of size class
+	Pools    int //num of pools
+	Capacity int //sum of cap of pools
+	Used     int //num of entries taken out of pools
+}
+
+//GrowthPolicy return the cap of the new pool when all pools are full, <= 0 means don't extend
+type GrowthPolicy interface {
+	NextPoolCap(s GrowthState) int
+}
+
+//FixedStepGrowth add a pool with Step entries, Step 0 means poolCap, it is the default
+type FixedStepGrowth struct {
+	Step int
+}
+
+func (g FixedStepGrowth) NextPoolCap(s GrowthState) int {
+	if g.Step > 0 {
+		return g.Step
+	}
+	return s.PoolCap
+}
+
+//GeometricGrowth make the capacity Factor times, e.g. 1.25 add 25% more entries
+type GeometricGrowth struct {
+	Factor float64
+}
+
+func (g GeometricGrowth) NextPoolCap(s GrowthState) int {
+	if g.Factor <= 1 {
+		return 0
+	}
+	n := int(math.Ceil(float64(s.Capacity) * (g.Factor - 1)))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+//TargetUtilGrowth add enough entries to bring utilisation Used/Capacity down to Target, e.g. 0.8
+type TargetUtilGrowth struct {
+	Target float64
+}
+
+func (g TargetUtilGrowth) NextPoolCap(s GrowthState) int {
+	if g.Target <= 0 || g.Target > 1 {
+		return 0
+	}
+	n := int(math.Ceil(float64(s.Used)/g.Target)) - s.Capacity
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+//checkGrowth check the parameters of the builtin GrowthPolicy, so a bad one fail in NewCachePool
+//instead of never extending, custom GrowthPolicy is not checked
+func checkGrowth(g GrowthPolicy) error {
+	switch g := g.(type) {
+	case FixedStepGrowth:
+		if g.Step < 0 || g.Step > MaxPoolSize {
+			return fmt.Errorf("FixedStepGrowth Step:%d out of range [0, %d]", g.Step, MaxPoolSize)
+		}
+	case GeometricGrowth:
+		if !(g.Factor > 1) {
+			return fmt.Errorf("GeometricGrowth Factor:%v must be > 1", g.Factor)
+		}
+	case TargetUtilGrowth:
+		if !(g.Target > 0 && g.Target <= 1) {
+			return fmt.Errorf("TargetUtilGrowth Target:%v must be in (0, 1]", g.Target)
+		}
+	}
+	return nil
+}
+
+//OptionWithGrowthPolicy set the GrowthPolicy, the parameters of the builtin ones are checked by NewCachePool
+func OptionWithGrowthPolicy(g GrowthPolicy) Option {
+	return func(c *CachePoolConf) {
+		c.growth = g
+	}
+}
+
+//OptionWithMaxCapacity limit the sum of cap of Value pools (and of every size class), 0 means no limit,
+//it is finer than OptionWithMaxPool since pools can have different cap
+func OptionWithMaxCapacity(n int) Option {
+	return func(c *CachePoolConf) {
+		c.maxCapacity = n
+	}
+}
+
+//cap of the next pool, it is clamped by maxCapacity, and rounded up to power of two for ring,
+//l is loaded once by the caller, so one extension see the same settings
+func (cp *cachePool) nextPoolCap(l *liveConf, pools []*Pool, poolCap int) int {
+	s := GrowthState{PoolCap: poolCap}
+	for _, p := range pools {
+		if p == nil {
+			continue
+		}
+		s.Pools++
+		s.Capacity += int(p.Cap())
+		s.Used += int(p.Used())
+	}
+	g := l.growth
+	if g == nil {
+		g = FixedStepGrowth{}
+	}
+	n := g.NextPoolCap(s)
+	if l.maxCapacity > 0 && n > l.maxCapacity-s.Capacity {
+		n = l.maxCapacity - s.Capacity
+	}
+	if n > MaxPoolSize {
+		n = MaxPoolSize
+	}
+	if n > 0 && resolvePositioner(cp.positioner) == PositionerRing && !IsPowerOfTwo(n) {
+		n = CeilToPowerOfTwo(n)
+		if l.maxCapacity > 0 && n > l.maxCapacity-s.Capacity {
+			n /= 2 //round down, ring can't use the rest
+		}
+	}
+	return n
+}
+
+//PoolStats is the snapshot of a pool
+type PoolStats struct {
+	Index      int
+	Cap        int
+	Used       int
+	Node       int
+	Positioner PositionerKind
+}
+
+//ClassStats is the snapshot of the pools of a size class
+type ClassStats struct {
+	Size     int
+	Capacity int
+	Used     int
+	Pools    []PoolStats
+}
+
+//Stats is the snapshot of cachePool, Used counts entries out of pools, include the ones in per-P caches
+type Stats struct {
+	Capacity    int
+	Used        int
+	Pools       []PoolStats
+	Classes     []ClassStats
+	DoubleFrees uint64
+	ForeignPuts uint64
+
+	MemoryUsage     int64  //bytes accounted for OptionWithMemoryLimit
+	MemoryLimitHits uint64 //num of extension shrunk or refused by memory limit
+}
+
+func poolStats(pools []*Pool) (capacity, used int, ps []PoolStats) {
+	for _, p := range pools {
+		if p == nil {
+			continue
+		}
+		s := PoolStats{Index: p.index, Cap: int(p.Cap()), Used: int(p.Used()), Node: p.node, Positioner: p.kind}
+		capacity += s.Cap
+		used += s.Used
+		ps = append(ps, s)
+	}
+	return
+}
+
+func (cp *cachePool) Stats() Stats {
+	var s Stats
+	s.Capacity, s.Used, s.Pools = poolStats(cp.loadPools())
+	for _, c := range cp.classes {
+		cs := ClassStats{Size: c.size}
+		cs.Capacity, cs.Used, cs.Pools = poolStats(c.loadPools())
+		s.Classes = append(s.Classes, cs)
+	}
+	s.DoubleFrees, s.ForeignPuts = cp.BadPuts()
+	s.MemoryUsage = cp.MemoryUsage()
+	s.MemoryLimitHits = atomic.LoadUint64(&cp.memoryLimitHits)
+	return s
+}
@@ -0,0 +1,79 @@
+package cachePool
+
+import (
+	"errors"
+	"unsafe"
+)
+
+/*
+handle: Value 和GetBytes 的[]byte 在shard map 里都是elemID (poolId + entryId), Handle 就是导出的elemID,
+没有指针，可以放在别的不被gc 扫描的结构里。StoreHandle/LoadHandle 对Value 和bytes 都适用，
+HandleValue/HandleBytes 按poolId 区分是哪一种, 类型不对返回nil.
+*/
+
+//Handle is the position of a Value or a byte slice of GetBytes in pools, it has no pointer
+type Handle uint64
+
+var ErrInvalidHandle = errors.New("handle is not a used entry of any pool")
+
+//ValueHandle return the handle of v got by GetValue
+func (cp *cachePool) ValueHandle(v *Value) (Handle, error) {
+	if v == nil || cp.findPool(v) == nil {
+		return 0, ErrForeignPointer
+	}
+	return Handle(GetElemID(v)), nil
+}
+
+//BytesHandle return the handle of b got by GetBytes, len(b) is recorded, so HandleBytes
+//return the slice with the same len
+func (cp *cachePool) BytesHandle(b []byte) (Handle, error) {
+	_, h, err := cp.bytesEntry(b)
+	if err != nil {
+		return 0, err
+	}
+	h.n = uint32(len(b))
+	return Handle(h.elemID()), nil
+}
+
+//pool and entry of h, nil if h is invalid or its pool is released
+func (cp *cachePool) handleEntry(h Handle) (*Pool, *Entry) {
+	eh := (*EntryHeader)(unsafe.Pointer(&h))
+	p := cp.getPool(eh.poolId)
+	if p == nil {
+		p = cp.getClassPool(eh.poolId)
+	}
+	if p == nil || p.invalid(eh.entryId) {
+		return nil, nil
+	}
+	return p, p.entryAt(eh.entryId)
+}
+
+//StoreHandle store key --> h, h must be a used entry of Value or size class pools
+func (cp *cachePool) StoreHandle(key Key, h Handle) error {
+	_, e := cp.handleEntry(h)
+	if e == nil || !e.isUsed() {
+		return ErrInvalidHandle
+	}
+	cp.sm.Lock()
+	cp.sm.set(&key, uint64(h))
+	cp.sm.Unlock()
+	return nil
+}
+
+//LoadHandle return the handle stored by Store, StoreBytes or StoreHandle
+func (cp *cachePool) LoadHandle(key Key) (Handle, bool) {
+	cp.sm.RLock()
+	elemID, ok := cp.sm.get(&key)
+	cp.sm.RUnlock()
+	return Handle(elemID), ok
+}
+
+//HandleValue return the Value of h, nil if h is not a Value
+func (cp *cachePool) HandleValue(h Handle) *Value {
+	return cp.getValueFromElemID(uint64(h))
+}
+
+//HandleBytes return the byte slice of h, nil if h is not a byte slice of GetBytes
+func (cp *cachePool) HandleBytes(h Handle) []byte {
+	return cp.getBytesFromElemID(uint64(h))
+}
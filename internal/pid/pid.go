@@ -0,0 +1,20 @@
+//Package pid give a hint of current P, and pin the goroutine to current P if it is
+//supported. by default it is portable, build with tag cachepool_procpin to use
+//runtime.procPin by go:linkname, which is faster but may be restricted by new go toolchain
+package pid
+
+import (
+	"runtime"
+)
+
+//numProcs is GOMAXPROCS when the process start, runtime.GOMAXPROCS(0) need a lock,
+//so don't call it on the hot path
+var numProcs = runtime.GOMAXPROCS(0)
+
+//Get return the id of current P, or a hint in [0, GOMAXPROCS) if P is not supported,
+//see Pin of pid_portable.go, the hint is not stable, it is only used to spread goroutines
+func Get() int {
+	id := Pin()
+	Unpin()
+	return id
+}
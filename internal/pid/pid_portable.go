@@ -0,0 +1,26 @@
+//go:build !cachepool_procpin
+// +build !cachepool_procpin
+
+package pid
+
+import (
+	"unsafe"
+)
+
+//Pinned means Pin() really disable preemption of current goroutine
+const Pinned = false
+
+//Pin can't pin goroutine without runtime support, it return a hint hashed from the
+//address of current stack frame. goroutines have different stacks, so they are spread
+//over [0, GOMAXPROCS), but the hint of a goroutine is not stable: it change with the
+//call depth and when the stack grows or moves, and it has nothing to do with current P
+func Pin() int {
+	var x byte
+	h := uint64(uintptr(unsafe.Pointer(&x)) >> 11) //initial goroutine stack size is 2KiB
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return int(h % uint64(numProcs))
+}
+
+func Unpin() {}
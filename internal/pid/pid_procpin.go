@@ -0,0 +1,26 @@
+//go:build cachepool_procpin
+// +build cachepool_procpin
+
+package pid
+
+import (
+	_ "unsafe"
+)
+
+//Pinned means Pin() really disable preemption of current goroutine
+const Pinned = true
+
+//go:linkname procPin runtime.procPin
+func procPin() int
+
+//go:linkname procUnpin runtime.procUnpin
+func procUnpin()
+
+//Pin pin current goroutine to its P and return the P id, must be followed by Unpin
+func Pin() int {
+	return procPin()
+}
+
+func Unpin() {
+	procUnpin()
+}
@@ -0,0 +1,143 @@
+package cachePool
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+leak tracker: GetValue 拿到的value 既没有Store 到shardMap，也没有put 回pool，就泄露了.
+开启后，每个pool 有一个跟entry 一一对应的side array，记录entry 分配的时间和调用者的pc，
+side array 不包含指针，gc 不扫描。LeakReport 列出分配时间超过olderThan、还在使用中，
+但是不在shardMap 里的entry.
+*/
+type leakRecord struct {
+	allocTime int64  //unix nano
+	pc        uint64 //caller of GetValue, not uintptr, so allocTime of every record is 8 bytes aligned on 32 bit platforms
+}
+
+type LeakInfo struct {
+	PoolId  int
+	EntryId int //entry index of pool buffer
+	Value   *Value
+	AllocAt time.Time
+	Caller  string //function file:line
+}
+
+func (l LeakInfo) String() string {
+	return fmt.Sprintf("pool:%d, entry:%d, alloc at:%s, age:%s, caller:%s", l.PoolId, l.EntryId,
+		l.AllocAt.Format(time.RFC3339Nano), time.Since(l.AllocAt), l.Caller)
+}
+
+var pkgPrefix = reflect.TypeOf(cachePool{}).PkgPath() + "."
+
+func OptionWithLeakTracking(b bool) Option {
+	return func(c *CachePoolConf) {
+		c.leakTracking = b
+	}
+}
+
+//pc of the first caller outside cachePool package
+func outerCallerPC() uintptr {
+	var pcs [16]uintptr
+	n := runtime.Callers(3, pcs[:])
+	for _, pc := range pcs[:n] {
+		f := runtime.FuncForPC(pc - 1)
+		if f == nil || !strings.HasPrefix(f.Name(), pkgPrefix) {
+			return pc
+		}
+	}
+	if n > 0 {
+		return pcs[n-1]
+	}
+	return 0
+}
+
+//record the allocation of entry if the pool track leaks
+func (p *Pool) trackAlloc(e *Entry) {
+	if p.leaks == nil {
+		return
+	}
+	r := &p.leaks[e.entryId]
+	atomic.StoreUint64(&r.pc, uint64(outerCallerPC()))
+	atomic.StoreInt64(&r.allocTime, time.Now().UnixNano())
+}
+
+func (cp *cachePool) trackAlloc(e *Entry) {
+	if !cp.leakTracking {
+		return
+	}
+	if p := cp.getPool(e.poolId); p != nil {
+		p.trackAlloc(e)
+	}
+}
+
+//keep the allocation record when Compact move entry from to entry to
+func (cp *cachePool) moveLeakRecord(from, to *Entry) {
+	if !cp.leakTracking {
+		return
+	}
+	pf, pt := cp.getPool(from.poolId), cp.getPool(to.poolId)
+	rf := &pf.leaks[from.entryId]
+	rt := &pt.leaks[to.entryId]
+	atomic.StoreUint64(&rt.pc, atomic.LoadUint64(&rf.pc))
+	atomic.StoreInt64(&rt.allocTime, atomic.LoadInt64(&rf.allocTime))
+}
+
+func symbolize(pc uintptr) string {
+	if pc == 0 {
+		return "unknown"
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	return fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line)
+}
+
+//LeakReport list values allocated before olderThan, but neither stored in shardMap nor put back,
+//it need OptionWithLeakTracking(true)
+func (cp *cachePool) LeakReport(olderThan time.Duration) []LeakInfo {
+	if !cp.leakTracking {
+		return nil
+	}
+	stored := make(map[uint64]struct{})
+	cp.sm.RLock()
+	cp.sm.rangeAll(func(_ Key, elemID uint64) {
+		stored[elemID] = struct{}{}
+	})
+	cp.sm.RUnlock()
+
+	deadline := time.Now().Add(-olderThan).UnixNano()
+	var leaks []LeakInfo
+	for _, p := range cp.loadPools() {
+		if p == nil || p.leaks == nil {
+			continue
+		}
+		for i := 0; i < int(p.size); i++ {
+			e := p.entryAt(uint32(i))
+			if atomic.LoadUint32(&e.nextFree)&UsedFlag == 0 {
+				continue
+			}
+			r := &p.leaks[i]
+			at := atomic.LoadInt64(&r.allocTime)
+			if at == 0 || at > deadline {
+				continue
+			}
+			if _, ok := stored[e.elemID()]; ok {
+				continue
+			}
+			leaks = append(leaks, LeakInfo{
+				PoolId:  p.index,
+				EntryId: i,
+				Value:   &e.Value,
+				AllocAt: time.Unix(0, at),
+				Caller:  symbolize(uintptr(atomic.LoadUint64(&r.pc))),
+			})
+		}
+	}
+	sort.Slice(leaks, func(i, j int) bool { return leaks[i].AllocAt.Before(leaks[j].AllocAt) })
+	return leaks
+}
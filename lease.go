@@ -0,0 +1,96 @@
+package cachePool
+
+import (
+	"sync/atomic"
+)
+
+/*
+lease: Load 返回的*Value 没有任何保护，别的goroutine DeleteAndFreeValue 后，entry 可能被重新分配，
+Acquire 增加entry 的引用计数，在引用计数归零前，PutEntry 只是标记freePending，
+最后一个release 才真正把entry 放回pool，这样读者可以安全地原地使用value, 不需要copy.
+
+引用计数和entry lock 共用Entry.state 一个word, 少一个word Entry 就小8 字节,
+从高到低: freePending (bit31), lease count (bit16-30), writer (bit15), reader count (bit0-14),
+lock 的CAS 只改低16 位, 会带上当时的高16 位, 所以lease 和lock 互不影响。
+*/
+const (
+	freePending = HigestBit //entry is freed while it is leased
+	leaseOne    = 1 << 16
+	leaseMask   = freePending - leaseOne //lease count
+)
+
+var noopRelease = func() {}
+
+//Acquire return the value of key and a release func, the value will not be freed until
+//release is called, release must be called once. value is nil if key is not exist
+func (cp *cachePool) Acquire(key Key) (*Value, func()) {
+	cp.sm.RLock()
+	elemID, ok := cp.sm.get(&key)
+	var e *Entry
+	if ok {
+		e = cp.getEntryFromElemID(elemID)
+	}
+	if e != nil {
+		//inc refs before RUnlock, so DeleteAndFreeValue must see the lease
+		e.addLease()
+	}
+	cp.sm.RUnlock()
+	if e == nil {
+		return nil, noopRelease
+	}
+	released := uint32(0)
+	return &e.Value, func() {
+		if atomic.CompareAndSwapUint32(&released, 0, 1) {
+			cp.release(e)
+		}
+	}
+}
+
+//count can't carry into freePending, so it is CAS instead of Add
+func (e *Entry) addLease() {
+	for {
+		s := atomic.LoadUint32(&e.state)
+		if s&leaseMask == leaseMask {
+			panic("too many leases on entry")
+		}
+		if atomic.CompareAndSwapUint32(&e.state, s, s+leaseOne) {
+			return
+		}
+	}
+}
+
+func (cp *cachePool) release(e *Entry) {
+	s := atomic.AddUint32(&e.state, ^uint32(leaseOne-1))
+	for s&(freePending|leaseMask) == freePending {
+		//the last lease and entry have been freed, free it now
+		if atomic.CompareAndSwapUint32(&e.state, s, s&^freePending) {
+			cp.freeEntry(e)
+			return
+		}
+		//the lock bits changed, or leased again
+		s = atomic.LoadUint32(&e.state)
+	}
+}
+
+//defer freeing entry if it is leased, return true if deferred,
+//ErrDoubleFree if it have been freed while leased
+func (cp *cachePool) deferFree(e *Entry) (bool, error) {
+	for {
+		s := atomic.LoadUint32(&e.state)
+		if s&freePending != 0 {
+			atomic.AddUint64(&cp.doubleFrees, 1)
+			return false, ErrDoubleFree
+		}
+		if s&leaseMask == 0 {
+			return false, nil
+		}
+		if atomic.CompareAndSwapUint32(&e.state, s, s|freePending) {
+			return true, nil
+		}
+	}
+}
+
+//Leases return the num of leases on value
+func Leases(v *Value) int {
+	return int(atomic.LoadUint32(&GetEntryFromElem(v).state) & leaseMask / leaseOne)
+}
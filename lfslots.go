@@ -0,0 +1,162 @@
+package cachePool
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+lock free slots: 跟slotsPosition 一样用slots[i].nextFree 串成空闲链表，但是不用锁，
+而是Treiber stack: head 是一个uint64, 高32位是tag(ABA 计数)，低32位是栈顶slot index，
+每次pop/push 都用一次CAS 修改head，并且tag+1，所以即使栈顶slot 被别人pop 后又push 回来(ABA)，
+CAS 也会失败。
+*/
+type lfSlotsPosition struct {
+	getRace uint64
+	_       CachePad
+	head    uint64 //tag<<32 | idle slot index
+	_       CachePad
+	slots   []EntryHeader //entryheader have no pointer, no scan
+}
+
+func packHead(tag, id uint32) uint64 {
+	return uint64(tag)<<32 | uint64(id)
+}
+
+func unpackHead(head uint64) (uint32, uint32) {
+	return uint32(head >> 32), uint32(head)
+}
+
+func (s *lfSlotsPosition) InitPosition(buffer []byte, poolIndex, cap, entrySize int) error {
+	s.slots = make([]EntryHeader, cap)
+	s.head = packHead(0, Invalid)
+	for i := len(s.slots) - 1; i >= 0; i-- {
+		s.slots[i].poolId = uint32(poolIndex)
+		s.slots[i].entryId = uint32(i)
+		if ok := s.Put(uint32(i)); !ok {
+			return fmt.Errorf("put id:%d fail", i)
+		}
+		e := (*Entry)(unsafe.Pointer(&buffer[i*entrySize]))
+		e.poolId = s.slots[i].poolId
+		e.entryId = s.slots[i].entryId
+		e.nextFree = uint32(i) //buffer's EntryHeader's nextFree correspond slot index
+	}
+	return nil
+}
+
+func (s *lfSlotsPosition) String() string {
+	tag, id := unpackHead(atomic.LoadUint64(&s.head))
+	ss := fmt.Sprintf("----LockFreeSlots, idleSlot=%d, tag=%d, getRace=%d-----\n", id, tag, atomic.LoadUint64(&s.getRace))
+	for i := range s.slots {
+		ss += fmt.Sprintf("%s\n", &s.slots[i])
+	}
+	return ss
+}
+
+func (s *lfSlotsPosition) invalid(id uint32) bool {
+	return id&Invalid != 0 || id >= uint32(len(s.slots))
+}
+
+//push the free slot
+func (s *lfSlotsPosition) Put(id uint32) bool {
+	if s.invalid(id) {
+		return false
+	}
+	for {
+		head := atomic.LoadUint64(&s.head)
+		tag, top := unpackHead(head)
+		atomic.StoreUint32(&s.slots[id].nextFree, top)
+		if atomic.CompareAndSwapUint64(&s.head, head, packHead(tag+1, id)) {
+			return true
+		}
+	}
+}
+
+//pop a free slot
+func (s *lfSlotsPosition) Get() uint32 {
+	for {
+		head := atomic.LoadUint64(&s.head)
+		tag, id := unpackHead(head)
+		if s.invalid(id) {
+			return id
+		}
+		//if slot id is popped by others, the tag of head must be changed, so CAS fail
+		next := atomic.LoadUint32(&s.slots[id].nextFree)
+		if atomic.CompareAndSwapUint64(&s.head, head, packHead(tag+1, next)) {
+			return id
+		}
+		atomic.AddUint64(&s.getRace, 1)
+	}
+}
+
+//here Entry is pool buffer'Entry
+func (s *lfSlotsPosition) PutEntryHeader(e *EntryHeader) bool {
+	e.nextFree &= IdMask
+	return s.Put(e.nextFree)
+}
+
+func (s *lfSlotsPosition) GetEntryHeader() EntryHeader {
+	id := s.Get()
+	if s.invalid(id) {
+		return InvalidEntryHeader
+	}
+	return s.slots[int(id)]
+}
+
+//link the slots of ehs to a list, then push the list with one CAS
+func (s *lfSlotsPosition) PutEntryHeaders(ehs []*EntryHeader) int {
+	first, last, n := uint32(Invalid), uint32(Invalid), 0
+	for _, e := range ehs {
+		e.nextFree &= IdMask
+		id := e.nextFree
+		if s.invalid(id) {
+			continue
+		}
+		if n == 0 {
+			first = id
+		} else {
+			atomic.StoreUint32(&s.slots[last].nextFree, id)
+		}
+		last = id
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	for {
+		head := atomic.LoadUint64(&s.head)
+		tag, top := unpackHead(head)
+		atomic.StoreUint32(&s.slots[last].nextFree, top)
+		if atomic.CompareAndSwapUint64(&s.head, head, packHead(tag+1, first)) {
+			return n
+		}
+	}
+}
+
+//walk min(len(dst), free num) slots from top, then pop them with one CAS,
+//if head is not changed (tag is the same), the slots walked are not changed either
+func (s *lfSlotsPosition) GetEntryHeaders(dst []EntryHeader) int {
+	if len(dst) == 0 {
+		return 0
+	}
+	for {
+		head := atomic.LoadUint64(&s.head)
+		tag, id := unpackHead(head)
+		n := 0
+		for n < len(dst) && !s.invalid(id) {
+			//poolId and entryId never change after init, nextFree may be written by a pusher
+			dst[n].entryPosition = s.slots[id].entryPosition
+			dst[n].nextFree = id
+			id = atomic.LoadUint32(&s.slots[id].nextFree)
+			n++
+		}
+		if n == 0 {
+			return 0
+		}
+		if atomic.CompareAndSwapUint64(&s.head, head, packHead(tag+1, id)) {
+			return n
+		}
+		atomic.AddUint64(&s.getRace, 1)
+	}
+}
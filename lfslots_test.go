@@ -0,0 +1,121 @@
+package cachePool
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+//stress the positioners, check no slot is lost or handed out twice,
+//run it with the race detector: go test -race -run TestLockFreeSlotsStress
+func TestLockFreeSlotsStress(t *testing.T) {
+	level := GetLogLevel()
+	SetLogLevel(LogOff)
+	defer SetLogLevel(level)
+
+	ops := 20000
+	if testing.Short() {
+		ops = 2000
+	}
+	for _, kind := range []PositionerKind{PositionerLockFreeSlots, PositionerSlots, PositionerRing, PositionerBitmap} {
+		t.Run(kind.String(), func(t *testing.T) {
+			testSlotsStress(t, kind, ops)
+		})
+	}
+}
+
+//values held by goroutines, a value must not be held by two goroutines
+type slotsOwners struct {
+	dups   uint64   //first field, so it is 8 bytes aligned on 32 bit platforms
+	owners sync.Map //*Value -> owner id
+}
+
+//v.A is written while held, so the race detector also reports a value handed out twice
+func (o *slotsOwners) hold(t *testing.T, v *Value, owner int) {
+	if prev, loaded := o.owners.LoadOrStore(v, owner); loaded {
+		atomic.AddUint64(&o.dups, 1)
+		t.Errorf("value %p handed out twice, owner:%d and %d", v, prev, owner)
+	}
+	v.A = owner
+}
+
+func (o *slotsOwners) release(t *testing.T, v *Value, owner int) {
+	if v.A != owner {
+		atomic.AddUint64(&o.dups, 1)
+		t.Errorf("value %p owner changed from %d to %d", v, owner, v.A)
+	}
+	o.owners.Delete(v)
+}
+
+func testSlotsStress(t *testing.T, kind PositionerKind, ops int) {
+	const (
+		poolNum = 2
+		poolCap = 64
+		batch   = 8 //max values held by a goroutine every round
+	)
+	goroutines := runtime.GOMAXPROCS(0) * 4
+	cp, err := NewCachePool(poolNum, poolCap, OptionWithPositioner(kind), OptionWithAutoExtend(false))
+	if err != nil {
+		t.Fatalf("NewCachePool: %v", err)
+	}
+
+	var o slotsOwners
+	var wg sync.WaitGroup
+	for g := 1; g <= goroutines; g++ {
+		wg.Add(1)
+		go func(owner int) {
+			defer wg.Done()
+			held := make([]*Value, batch)
+			for i := 0; i < ops; i++ {
+				k := 1 + i%batch
+				n := 0
+				if i&1 == 0 {
+					for ; n < k; n++ {
+						v := cp.GetValue()
+						if v == nil {
+							break
+						}
+						held[n] = v
+					}
+				} else {
+					n = cp.GetValues(held[:k])
+				}
+				for _, v := range held[:n] {
+					o.hold(t, v, owner)
+				}
+				runtime.Gosched()
+				for _, v := range held[:n] {
+					o.release(t, v, owner)
+				}
+				if i&2 == 0 {
+					for _, v := range held[:n] {
+						if err := cp.PutValue(v); err != nil {
+							t.Errorf("PutValue: %v", err)
+							return
+						}
+					}
+				} else if put, err := cp.PutValues(held[:n]); put != n || err != nil {
+					t.Errorf("PutValues: put %d of %d, %v", put, n, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if err := cp.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	//all slots must come back: drain the pool, every value is got exactly once
+	seen := make(map[*Value]bool)
+	for v := cp.GetValue(); v != nil; v = cp.GetValue() {
+		if seen[v] {
+			t.Fatalf("value %p got twice when draining", v)
+		}
+		seen[v] = true
+	}
+	if len(seen) != cp.Capacity() || o.dups != 0 {
+		t.Fatalf("drained %d of capacity %d, dups:%d", len(seen), cp.Capacity(), o.dups)
+	}
+}
@@ -0,0 +1,198 @@
+package cachePool
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+/*
+per-P local cache, like syncPool's poolLocal private/shared:
+每个P 对应一个小的 magazine，缓存一批空闲的entry，GetValue/PutValue 优先从本地magazine 取/放，
+magazine 空了就从PoolSelector 选的pool 开始, 从positioner 批量 refill，满了就 flush 一半回 pool，
+这样大部分get/put 不需要去竞争 pool 的SpinLock 或者 ring 的 headtail CAS.
+
+magazine 里的entry 是空闲的(UsedFlag 已清除)，但不在positioner 里。
+所有pool 都取不到时，先从所有P 的magazine 里偷一个(stealLocal), 偷不到才扩展或返回nil,
+否则别的P 空闲了, 它magazine 里的entry 永远不会被用到, cachePool 会白白扩展。
+*/
+type localCache struct {
+	lock  uint32   //try lock, only the owner P use it normally, so it is almost uncontended
+	n     int      //free entry num in elems
+	elems []uint64 //elemID of free entries, no pointer, gc will not scan it
+	_     CachePad
+}
+
+func (l *localCache) tryLock() bool {
+	return atomic.CompareAndSwapUint32(&l.lock, 0, 1)
+}
+
+func (l *localCache) unlock() {
+	atomic.StoreUint32(&l.lock, 0)
+}
+
+func OptionWithLocalCache(size int) Option {
+	return func(c *CachePoolConf) {
+		c.localCacheSize = size
+	}
+}
+
+func (cp *cachePool) initLocalCaches() {
+	if cp.localCacheSize <= 0 {
+		return
+	}
+	cp.locals = make([]localCache, runtime.GOMAXPROCS(0))
+	for i := range cp.locals {
+		cp.locals[i].elems = make([]uint64, cp.localCacheSize)
+	}
+}
+
+//get the localCache of current P, nil if local cache is disable or GOMAXPROCS is increased
+func (cp *cachePool) pinLocal() *localCache {
+	pid := getPid()
+	if pid >= len(cp.locals) {
+		return nil
+	}
+	l := &cp.locals[pid]
+	if !l.tryLock() {
+		//other goroutine is using it, go to the pool directly instead of waiting
+		return nil
+	}
+	return l
+}
+
+func (cp *cachePool) getLocalEntry() *Entry {
+	l := cp.pinLocal()
+	if l == nil {
+		return nil
+	}
+	if l.n == 0 {
+		cp.refillLocal(l)
+	}
+	var e *Entry
+	for e == nil && l.n > 0 {
+		l.n--
+		e = cp.getEntryFromElemID(l.elems[l.n])
+	}
+	l.unlock()
+	if e == nil {
+		return nil
+	}
+	cp.allocLocalEntry(e)
+	return e
+}
+
+//mark the entry taken from magazine as used
+func (cp *cachePool) allocLocalEntry(e *Entry) {
+	if e.isUsed() {
+		panic("getLocalEntry: entry have been used?")
+	}
+	atomic.StoreUint32(&e.nextFree, e.nextFree|UsedFlag)
+	if debugBuild {
+		debugCheckAlloc(cp.getPool(e.poolId), e)
+	}
+	cp.trackAlloc(e)
+}
+
+//stealLocal take a free entry from the magazines of all Ps, start from the next P.
+//it is called when all pools are empty, before extending or returning nil,
+//otherwise the entries cached by idle Ps are never reused.
+//the caller must not hold any magazine, it wait for the magazine locked by others
+func (cp *cachePool) stealLocal() *Entry {
+	num := len(cp.locals)
+	start := getPid()
+	for i := 1; i <= num; i++ {
+		l := &cp.locals[(start+i)%num]
+		for !l.tryLock() {
+			spinYield()
+		}
+		var e *Entry
+		for e == nil && l.n > 0 {
+			l.n--
+			e = cp.getEntryFromElemID(l.elems[l.n])
+		}
+		l.unlock()
+		if e != nil {
+			cp.allocLocalEntry(e)
+			return e
+		}
+	}
+	return nil
+}
+
+//refill half of magazine from pools, start from the pool chosen by PoolSelector
+func (cp *cachePool) refillLocal(l *localCache) {
+	want := (len(l.elems) + 1) / 2
+	pools := cp.loadPools()
+	max := len(pools)
+	start := cp.selectPool(pools, 0, false)
+	var ehs [batchSize]EntryHeader
+	for i := 0; i < max && l.n < want; i++ {
+		p := pools[(start+i)%max]
+		if p == nil || p.isDraining() {
+			continue
+		}
+		for l.n < want {
+			k := want - l.n
+			if k > batchSize {
+				k = batchSize
+			}
+			n := p.positioner.GetEntryHeaders(ehs[:k])
+			p.addUsed(n)
+			if p.putBackIfDraining(ehs[:n]) {
+				break
+			}
+			for j := 0; j < n; j++ {
+				l.elems[l.n] = ehs[j].elemID()
+				l.n++
+			}
+			if n < k {
+				break
+			}
+		}
+	}
+}
+
+//put the free entry to magazine of current P, the UsedFlag of entry must have been cleaned
+func (cp *cachePool) putLocalEntry(e *Entry) bool {
+	l := cp.pinLocal()
+	if l == nil {
+		return false
+	}
+	if l.n == len(l.elems) {
+		cp.flushLocal(l, len(l.elems)/2)
+	}
+	if l.n == len(l.elems) {
+		l.unlock()
+		return false
+	}
+	l.elems[l.n] = e.elemID()
+	l.n++
+	l.unlock()
+	return true
+}
+
+//flush n entries of magazine back to their pools, entries of the same pool
+//are put in batch
+func (cp *cachePool) flushLocal(l *localCache, n int) {
+	if n <= 0 {
+		n = 1
+	}
+	var es [batchSize]*Entry
+	k := 0
+	for ; n > 0 && l.n > 0; n-- {
+		l.n--
+		e := cp.getEntryFromElemID(l.elems[l.n])
+		if e == nil {
+			continue
+		}
+		if k == batchSize || (k > 0 && e.poolId != es[0].poolId) {
+			cp.getPool(es[0].poolId).PutEntries(es[:k])
+			k = 0
+		}
+		es[k] = e
+		k++
+	}
+	if k > 0 {
+		cp.getPool(es[0].poolId).PutEntries(es[:k])
+	}
+}
@@ -2,18 +2,128 @@ package cachePool
 
 import (
 	"fmt"
+	"strings"
+	"sync/atomic"
 )
 
-func debug(format string, a ...interface{}) (n int, err error) {
+/*
+log level: 每个cachePool 有自己的level (OptionWithLogLevel, Config.LogLevel, Reconfigure 可以改),
+放在liveConf 里，cachePool 的方法用cp.debug/cp.info... 打印；LogDefault 表示跟随全局的SetLogLevel.
+Pool、positioner 这些不知道属于哪个cachePool 的代码只看全局level.
+全局level 默认是info, 只打印扩展、compact、reshard 这种少见的事件，debug 每次GetValue 都会打印。
+*/
+
+//LogLevel of the messages cachePool print, messages below the level are dropped
+type LogLevel int32
+
+const (
+	LogDefault LogLevel = iota //cachePool follow the global level of SetLogLevel
+	LogDebug                   //print every GetValue
+	LogInfo                    //default global level
+	LogNotice
+	LogWarn
+	LogOff
+)
+
+var logLevel = int32(LogInfo) //LogLevel, read by every log call, so it is atomic
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDefault:
+		return "default"
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogNotice:
+		return "notice"
+	case LogWarn:
+		return "warn"
+	case LogOff:
+		return "off"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int32(l))
+}
+
+//ParseLogLevel parse default, debug, info, notice, warn or off, "" is default
+func ParseLogLevel(s string) (LogLevel, error) {
+	for l := LogDefault; l <= LogOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	if s == "" {
+		return LogDefault, nil
+	}
+	return LogDefault, fmt.Errorf("unknown log level:%q", s)
+}
+
+//OptionWithLogLevel set the level of this cachePool, LogDefault means following SetLogLevel
+func OptionWithLogLevel(l LogLevel) Option {
+	return func(c *CachePoolConf) {
+		c.logLevel = l
+	}
+}
+
+//SetLogLevel set the global level, it is used by the cachePools whose level is LogDefault,
+//it is safe to call at any time. LogDefault is the same as LogInfo here
+func SetLogLevel(l LogLevel) {
+	if l == LogDefault {
+		l = LogInfo
+	}
+	atomic.StoreInt32(&logLevel, int32(l))
+}
+
+func GetLogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(&logLevel))
+}
+
+//LogLevel return the level of cp, it is the global one if cp follow it
+func (cp *cachePool) LogLevel() LogLevel {
+	if l := cp.live().logLevel; l != LogDefault {
+		return l
+	}
+	return GetLogLevel()
+}
+
+func logEnabled(l, level LogLevel) bool {
+	return l >= level && l < LogOff
+}
+
+func logf(l LogLevel, format string, a ...interface{}) (n int, err error) {
+	if !logEnabled(l, GetLogLevel()) {
+		return 0, nil
+	}
 	return fmt.Printf(format, a...)
 }
-func info(format string, a ...interface{}) (n int, err error) {
+
+func (cp *cachePool) logf(l LogLevel, format string, a ...interface{}) (n int, err error) {
+	if !logEnabled(l, cp.LogLevel()) {
+		return 0, nil
+	}
 	return fmt.Printf(format, a...)
 }
+
+func debug(format string, a ...interface{}) (n int, err error) {
+	return logf(LogDebug, format, a...)
+}
+func info(format string, a ...interface{}) (n int, err error) {
+	return logf(LogInfo, format, a...)
+}
 func notice(format string, a ...interface{}) (n int, err error) {
-	return fmt.Printf(format, a...)
+	return logf(LogNotice, format, a...)
 }
 
 func warn(format string, a ...interface{}) (n int, err error) {
-	return fmt.Printf(format, a...)
+	return logf(LogWarn, format, a...)
+}
+
+func (cp *cachePool) debug(format string, a ...interface{}) (n int, err error) {
+	return cp.logf(LogDebug, format, a...)
+}
+func (cp *cachePool) info(format string, a ...interface{}) (n int, err error) {
+	return cp.logf(LogInfo, format, a...)
+}
+func (cp *cachePool) notice(format string, a ...interface{}) (n int, err error) {
+	return cp.logf(LogNotice, format, a...)
 }
@@ -0,0 +1,120 @@
+package cachePool
+
+import (
+	"errors"
+	"math"
+	rtdebug "runtime/debug"
+	"runtime/metrics"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+memory limit: 按字节限制cachePool 的内存, 每个pool 计算:
+	buffer: cap*entrySize
+	positioner 元数据: slots/ring 每个entry 一个EntryHeader, bitmap 每个entry 1 bit
+	leak tracking: 每个entry 一个leakRecord
+	shard map: 每个entry 都可能被Store 到shard map, 按map bucket 的装载因子估算
+扩展时新pool 的cap 会被缩小到刚好不超过限制，放不下一个entry 就不再扩展。
+cachePool 没有淘汰，超过限制时只能拒绝扩展，GetValue 返回nil。
+
+另外设置了OptionWithMemoryLimit 时, 如果进程设置了debug.SetMemoryLimit (或者GOMEMLIMIT)，
+进程内存到了soft limit 的softLimitRatio 时也不再扩展，避免cachePool 把进程推到GC 一直运行的状态。
+没有设置OptionWithMemoryLimit 时不检查soft limit, 扩展跟以前一样只受maxPool/maxCapacity/GrowthPolicy 限制。
+*/
+
+const softLimitRatio = 0.9
+
+var ErrMemoryLimit = errors.New("memory limit of cachePool is exceeded")
+
+//go map bucket has 8 keys, 8 values, 8 tophash and overflow pointer, average load factor is 6.5
+var mapEntryBytes = float64(8*(unsafe.Sizeof(Key{})+8+1)+8) / 6.5
+
+//OptionWithMemoryLimit limit the bytes of pools buffer, positioner metadata and estimated shard map, 0 means no limit.
+//with a limit, pools also stop extending when the process is near the soft limit of GOMEMLIMIT
+func OptionWithMemoryLimit(bytes int64) Option {
+	return func(c *CachePoolConf) {
+		c.memoryLimit = bytes
+	}
+}
+
+//bytes of a pool with cap entries
+func (c *CachePoolConf) poolMemory(cap, entrySize int) int64 {
+	n := float64(cap) * (float64(entrySize) + mapEntryBytes)
+	switch resolvePositioner(c.positioner) {
+	case PositionerBitmap:
+		words := (cap + 63) / 64
+		n += float64(words+(words+63)/64) * 8
+	default:
+		n += float64(cap) * float64(unsafe.Sizeof(EntryHeader{}))
+	}
+	if c.leakTracking {
+		n += float64(cap) * float64(unsafe.Sizeof(leakRecord{}))
+	}
+	return int64(math.Ceil(n))
+}
+
+//MemoryUsage return the bytes accounted for OptionWithMemoryLimit
+func (cp *cachePool) MemoryUsage() int64 {
+	return atomic.LoadInt64(&cp.memoryUsed)
+}
+
+//bytes the process can still use before it near the soft limit of debug.SetMemoryLimit
+func softLimitRoom() int64 {
+	limit := rtdebug.SetMemoryLimit(-1)
+	if limit == math.MaxInt64 {
+		return math.MaxInt64
+	}
+	//the same as what the GC count for the memory limit
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	used := int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+	return int64(float64(limit)*softLimitRatio) - used
+}
+
+//reserve memory for a new pool with cap entries, return the cap reserved, 0 means no room.
+//when extend, cap is shrunk to fit the room, and the soft limit of process is considered too
+//if memoryLimit is set.
+//l is the liveConf loaded by the caller
+func (cp *cachePool) reserveMemory(l *liveConf, cap, entrySize int, extend bool) int {
+	for {
+		usage := atomic.LoadInt64(&cp.memoryUsed)
+		room := int64(math.MaxInt64)
+		if limit := l.memoryLimit; limit > 0 {
+			room = limit - usage
+			if extend {
+				if soft := softLimitRoom(); soft < room {
+					room = soft
+				}
+			}
+		}
+		n := cap
+		if need := cp.poolMemory(cap, entrySize); need > room {
+			n = 0
+			if extend && room > 0 {
+				//average bytes of entry plus one for rounding of bitmap words
+				n = int(room / (need/int64(cap) + 1))
+				for n > 0 && cp.poolMemory(n, entrySize) > room {
+					n--
+				}
+				if n > 0 && resolvePositioner(cp.positioner) == PositionerRing {
+					n = FloorToPowerOfTwo(n)
+				}
+			}
+			atomic.AddUint64(&cp.memoryLimitHits, 1)
+			if n == 0 {
+				return 0
+			}
+		}
+		if atomic.CompareAndSwapInt64(&cp.memoryUsed, usage, usage+cp.poolMemory(n, entrySize)) {
+			return n
+		}
+	}
+}
+
+func (cp *cachePool) releaseMemory(cap, entrySize int) {
+	atomic.AddInt64(&cp.memoryUsed, -cp.poolMemory(cap, entrySize))
+}
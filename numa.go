@@ -0,0 +1,98 @@
+package cachePool
+
+import (
+	"sync/atomic"
+)
+
+/*
+NUMA-aware pool placement:
+双路服务器上，GetValue 可能把node1 first-touch 的内存给了运行在node0 上的goroutine，跨node 访问内存慢。
+开启 OptionWithNUMA 后：
+1. pool i 属于 node i%nodeNum，pool 的buffer 由一个绑定在该node cpu 上的线程分配并初始化(first-touch)
+2. GetValue 优先从当前cpu 所在node 的pool 里获取对象
+单node 机器或者非linux 系统，退化为原来的行为
+*/
+
+//NUMA node info, nodeCPUs[i] is the cpu list of node i
+var numaNodeCPUs [][]int
+
+func OptionWithNUMA(b bool) Option {
+	return func(c *CachePoolConf) {
+		c.numa = b
+	}
+}
+
+func numaNodeNum() int {
+	return len(numaNodeCPUs)
+}
+
+func (cp *cachePool) numaEnabled() bool {
+	return cp.numa && numaNodeNum() > 1
+}
+
+//node of the pool in pools[index]
+func (cp *cachePool) poolNode(index int) int {
+	if !cp.numaEnabled() {
+		return 0
+	}
+	return index % numaNodeNum()
+}
+
+//run f on a thread pinned to the cpus of node, so the memory f touch first is on that node
+func (cp *cachePool) runOnNode(node int, f func()) {
+	if !cp.numaEnabled() {
+		f()
+		return
+	}
+	runOnCPUs(numaNodeCPUs[node], f)
+}
+
+//the node of current cpu is cached per P, refresh it every numaRefresh calls
+const numaRefresh = 256
+
+type numaLocal struct {
+	node  int32
+	calls uint32
+	_     CachePad
+}
+
+//numaSelector start from the pools of the local node, chose one of them by current P
+type numaSelector struct {
+	locals []numaLocal
+}
+
+func newNUMASelector(procs int) *numaSelector {
+	s := &numaSelector{locals: make([]numaLocal, procs)}
+	for i := range s.locals {
+		s.locals[i].node = -1
+	}
+	return s
+}
+
+func (s *numaSelector) localNode(pid int) int {
+	if pid >= len(s.locals) {
+		return currentNode()
+	}
+	l := &s.locals[pid]
+	node := atomic.LoadInt32(&l.node)
+	if node < 0 || atomic.AddUint32(&l.calls, 1)%numaRefresh == 0 {
+		node = int32(currentNode())
+		atomic.StoreInt32(&l.node, node)
+	}
+	return int(node)
+}
+
+func (s *numaSelector) SelectPool(pools []*Pool, hint uint64, hasKey bool) int {
+	pid := getPid()
+	nodeNum := numaNodeNum()
+	node := s.localNode(pid)
+	if node < 0 || node >= nodeNum {
+		return pid
+	}
+	//pools[node], pools[node+nodeNum], pools[node+2*nodeNum] ... are on the local node
+	groups := (len(pools) - node + nodeNum - 1) / nodeNum
+	if groups <= 0 {
+		return pid
+	}
+	return node + nodeNum*(pid%groups)
+}
@@ -0,0 +1,113 @@
+package cachePool
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const sysNodePath = "/sys/devices/system/node"
+
+func init() {
+	numaNodeCPUs = readNUMANodes(sysNodePath)
+}
+
+//read /sys/devices/system/node/node*/cpulist
+func readNUMANodes(path string) [][]int {
+	dirs, err := filepath.Glob(filepath.Join(path, "node[0-9]*"))
+	if err != nil || len(dirs) == 0 {
+		return nil
+	}
+	nodes := make(map[int][]int)
+	maxNode := -1
+	for _, dir := range dirs {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, "cpulist"))
+		if err != nil {
+			continue
+		}
+		cpus := parseCPUList(strings.TrimSpace(string(b)))
+		if len(cpus) == 0 {
+			//memory only node
+			continue
+		}
+		nodes[id] = cpus
+		if id > maxNode {
+			maxNode = id
+		}
+	}
+	//node id must be continuous, or give up
+	if len(nodes) != maxNode+1 {
+		return nil
+	}
+	nodeCPUs := make([][]int, len(nodes))
+	for id, cpus := range nodes {
+		nodeCPUs[id] = cpus
+	}
+	return nodeCPUs
+}
+
+//parse cpulist like "0-3,8-11,16"
+func parseCPUList(s string) []int {
+	var cpus []int
+	for _, field := range strings.Split(s, ",") {
+		if field == "" {
+			continue
+		}
+		lo, hi := field, field
+		if i := strings.IndexByte(field, '-'); i >= 0 {
+			lo, hi = field[:i], field[i+1:]
+		}
+		start, err1 := strconv.Atoi(lo)
+		end, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || start > end {
+			return nil
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	sort.Ints(cpus)
+	return cpus
+}
+
+//runOnCPUs run f on a new thread whose affinity is cpus, the thread is
+//terminated after f return, because the goroutine exit without UnlockOSThread
+func runOnCPUs(cpus []int, f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+		var mask [16]uint64 //1024 cpus
+		for _, cpu := range cpus {
+			if cpu < len(mask)*64 {
+				mask[cpu/64] |= 1 << uint(cpu%64)
+			}
+		}
+		_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0,
+			uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+		if errno != 0 {
+			warn("numa: sched_setaffinity fail:%s\n", errno)
+		}
+		f()
+	}()
+	<-done
+}
+
+//currentNode return the node of the cpu current thread running on, -1 if fail
+func currentNode() int {
+	var cpu, node uint32
+	_, _, errno := syscall.RawSyscall(sysGetcpu, uintptr(unsafe.Pointer(&cpu)), uintptr(unsafe.Pointer(&node)), 0)
+	if errno != 0 {
+		return -1
+	}
+	return int(node)
+}
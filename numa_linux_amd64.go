@@ -0,0 +1,4 @@
+package cachePool
+
+//syscall package has no SYS_GETCPU on linux/amd64
+const sysGetcpu = 309
@@ -0,0 +1,8 @@
+//go:build linux && !amd64
+// +build linux,!amd64
+
+package cachePool
+
+import "syscall"
+
+const sysGetcpu = syscall.SYS_GETCPU
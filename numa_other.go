@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package cachePool
+
+//no NUMA support, OptionWithNUMA is no-op
+
+func runOnCPUs(cpus []int, f func()) {
+	f()
+}
+
+func currentNode() int {
+	return -1
+}
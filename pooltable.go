@@ -0,0 +1,121 @@
+package cachePool
+
+import (
+	"sort"
+	"unsafe"
+)
+
+/*
+pool table: GetValue、Load 等读pools 时不加锁，而扩展、Compact 会修改pools，
+所以pools 做成不可修改的快照poolTable, 修改时在cp.Lock()(或sizeClass 的锁)下copy 一份新的，
+改完用atomic.Pointer 发布，读者拿到的快照永远不会被修改，也就不会读到append 了一半的slice.
+pool 很少扩展，copy 的代价可以忽略。
+*/
+type poolTable struct {
+	pools []*Pool //never modified after published, nil means released by Compact
+	num   int     //num of pools which are not nil
+}
+
+//return a new table with pools[index] = p, index can be len(pools) to append
+func (t *poolTable) with(index int, p *Pool) *poolTable {
+	nt := &poolTable{num: t.num}
+	n := len(t.pools)
+	if index >= n {
+		n = index + 1
+	}
+	nt.pools = make([]*Pool, n)
+	copy(nt.pools, t.pools)
+	if nt.pools[index] != nil {
+		nt.num--
+	}
+	if p != nil {
+		nt.num++
+	}
+	nt.pools[index] = p
+	return nt
+}
+
+//nil if index is out of range or the pool is released
+func (t *poolTable) get(index uint32) *Pool {
+	if int(index) >= len(t.pools) {
+		return nil
+	}
+	return t.pools[index]
+}
+
+//snapshot of Value pools, it must not be modified
+func (cp *cachePool) loadPools() []*Pool {
+	return cp.table.Load().pools
+}
+
+func (cp *cachePool) getPool(index uint32) *Pool {
+	return cp.table.Load().get(index)
+}
+
+//must be called with cp.Lock() held, or at init
+func (cp *cachePool) setPool(index int, p *Pool) {
+	t := cp.table.Load()
+	if old := t.get(uint32(index)); old != nil {
+		cp.removeSpan(old)
+	}
+	if p != nil {
+		cp.addSpan(p)
+	}
+	cp.table.Store(t.with(index, p))
+}
+
+/*
+address index: PutValue/PutBytes 要确认指针来自某个pool 的buffer, 而且在entry 的边界上。
+所有pool (Value 和size class) 的buffer 地址区间按地址排序，二分查找，只比较地址，不读指针指向的内存。
+Value pool 在cp.Lock 下扩展, size class pool 在sizeClass 的锁下扩展, 所以修改索引用单独的spansMu,
+跟poolTable 一样copy 一份新的再atomic 发布。buffer 不会被gc 移动，只要*Pool 还在索引里, 地址就有效。
+*/
+type poolSpan struct {
+	base, end uintptr
+	p         *Pool
+}
+
+type poolSpans []poolSpan //sorted by base, never modified after published
+
+func (cp *cachePool) addSpan(p *Pool) {
+	base := uintptr(unsafe.Pointer(&p.buffer[0]))
+	cp.spansMu.Lock()
+	old := cp.loadSpans()
+	i := sort.Search(len(old), func(i int) bool { return old[i].base > base })
+	spans := make(poolSpans, 0, len(old)+1)
+	spans = append(spans, old[:i]...)
+	spans = append(spans, poolSpan{base: base, end: base + uintptr(len(p.buffer)), p: p})
+	spans = append(spans, old[i:]...)
+	cp.spans.Store(&spans)
+	cp.spansMu.Unlock()
+}
+
+func (cp *cachePool) removeSpan(p *Pool) {
+	cp.spansMu.Lock()
+	old := cp.loadSpans()
+	spans := make(poolSpans, 0, len(old))
+	for _, s := range old {
+		if s.p != p {
+			spans = append(spans, s)
+		}
+	}
+	cp.spans.Store(&spans)
+	cp.spansMu.Unlock()
+}
+
+func (cp *cachePool) loadSpans() poolSpans {
+	if spans := cp.spans.Load(); spans != nil {
+		return *spans
+	}
+	return nil
+}
+
+//poolOfAddr return the pool whose buffer contains addr and the offset of addr in buffer
+func (cp *cachePool) poolOfAddr(addr uintptr) (*Pool, uintptr) {
+	spans := cp.loadSpans()
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].end > addr })
+	if i == len(spans) || addr < spans[i].base {
+		return nil, 0
+	}
+	return spans[i].p, addr - spans[i].base
+}
@@ -0,0 +1,176 @@
+package cachePool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//hammer GetValue/Load/GetBytes while pools are extended, compacted and resharded,
+//run it with the race detector: go test -race -run TestPoolTableRace
+func TestPoolTableRace(t *testing.T) {
+	level := GetLogLevel()
+	SetLogLevel(LogOff)
+	defer SetLogLevel(level)
+
+	duration := time.Second
+	if testing.Short() {
+		duration = 200 * time.Millisecond
+	}
+	for _, kind := range []PositionerKind{PositionerLockFreeSlots, PositionerSlots, PositionerRing, PositionerBitmap} {
+		t.Run(kind.String(), func(t *testing.T) {
+			testPoolTableRace(t, kind, duration)
+		})
+	}
+}
+
+func testPoolTableRace(t *testing.T, kind PositionerKind, duration time.Duration) {
+	const (
+		goroutines = 16
+		poolCap    = 8 //small cap make extension frequent
+		keys       = 64
+	)
+	cp, err := NewCachePool(1, poolCap,
+		OptionWithPositioner(kind),
+		OptionWithLocalCache(4),
+		OptionWithSizeClasses(64, 256, poolCap))
+	if err != nil {
+		t.Fatalf("NewCachePool: %v", err)
+	}
+
+	var ops, mismatches, lost uint64
+	var stop int32
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			//it is never deleted, it must be found by Acquire during Compact and Reshard
+			pinned := Key{A: g, B: -1}
+			if v := cp.GetValue(); v != nil {
+				v.A, v.B = pinned.A, pinned.B
+				cp.Store(pinned, v)
+			} else {
+				pinned.C = -1
+			}
+			for i := 0; atomic.LoadInt32(&stop) == 0; i++ {
+				key := Key{A: g, B: i % keys}
+				switch i % 5 {
+				case 0:
+					//keep the values of half of keys, so pools keep growing
+					if v := cp.GetValue(); v != nil {
+						v.A, v.B = key.A, key.B
+						cp.DeleteAndFreeValue(key)
+						cp.Store(key, v)
+					}
+				case 1:
+					//leased value is not freed or moved by Compact
+					v, release := cp.Acquire(key)
+					if v != nil && (v.A != key.A || v.B != key.B) {
+						atomic.AddUint64(&mismatches, 1)
+					}
+					release()
+				case 2:
+					if key.B%2 == 0 {
+						cp.DeleteAndFreeValue(key)
+					}
+				case 3:
+					bkey := Key{A: g, B: i % keys, C: 1}
+					if b := cp.GetBytes(100); b != nil {
+						copy(b, "hello")
+						cp.DeleteAndFreeBytes(bkey)
+						cp.StoreBytes(bkey, b)
+					}
+				case 4:
+					if pinned.C == 0 {
+						v, release := cp.Acquire(pinned)
+						if v == nil {
+							atomic.AddUint64(&lost, 1)
+						} else if v.A != pinned.A || v.B != pinned.B {
+							atomic.AddUint64(&mismatches, 1)
+						}
+						release()
+					}
+					cp.GetPoolNum()
+					cp.Capacity()
+					cp.Stats()
+				}
+				atomic.AddUint64(&ops, 1)
+			}
+		}(g)
+	}
+
+	compacted := CompactStats{}
+	deadline := time.Now().Add(duration)
+	for i := 0; time.Now().Before(deadline); i++ {
+		if err := cp.Reshard(1 << (i % 7)); err != nil {
+			t.Errorf("Reshard: %v", err)
+		}
+		st, _ := cp.Compact(context.Background())
+		compacted.Moved += st.Moved
+		compacted.Released += st.Released
+		time.Sleep(time.Millisecond)
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+
+	t.Logf("ops:%d, pools:%d, capacity:%d, shards:%d, compact moved:%d, released:%d",
+		ops, cp.GetPoolNum(), cp.Capacity(), cp.ShardSize(), compacted.Moved, compacted.Released)
+	if mismatches != 0 || lost != 0 {
+		t.Fatalf("mismatches:%d, lost:%d", mismatches, lost)
+	}
+	if err := cp.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+//the index of a pool released by Compact is not reused, so handles of the released pool
+//don't resolve to the pool extended later
+func TestReleasedPoolIndexNotReused(t *testing.T) {
+	level := GetLogLevel()
+	SetLogLevel(LogOff)
+	defer SetLogLevel(level)
+
+	const poolCap = 4
+	cp, err := NewCachePool(4, poolCap)
+	if err != nil {
+		t.Fatalf("NewCachePool: %v", err)
+	}
+	var handles []Handle
+	var vs []*Value
+	for i, n := 0, cp.Capacity(); i < n; i++ {
+		v := cp.GetValue()
+		h, err := cp.ValueHandle(v)
+		if err != nil {
+			t.Fatalf("ValueHandle: %v", err)
+		}
+		handles = append(handles, h)
+		vs = append(vs, v)
+	}
+	if _, err := cp.PutValues(vs); err != nil {
+		t.Fatalf("PutValues: %v", err)
+	}
+	st, err := cp.Compact(context.Background())
+	if err != nil || st.Released == 0 {
+		t.Fatalf("Compact: %+v, %v", st, err)
+	}
+	var released []Handle
+	for _, h := range handles {
+		if cp.HandleValue(h) == nil {
+			released = append(released, h)
+		}
+	}
+	//extend pools again
+	for i := 0; i < 4*poolCap; i++ {
+		if cp.GetValue() == nil {
+			t.Fatalf("GetValue fail after Compact")
+		}
+	}
+	for _, h := range released {
+		if v := cp.HandleValue(h); v != nil {
+			t.Fatalf("handle %x of released pool resolve to %p", h, v)
+		}
+	}
+}
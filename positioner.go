@@ -0,0 +1,62 @@
+package cachePool
+
+import "fmt"
+
+//PositionerKind chose the EntryPositioner of pools
+type PositionerKind int
+
+const (
+	PositionerDefault       PositionerKind = iota //slots or ring, by flag useSlots
+	PositionerSlots                               //slotsPosition, free list guarded by AdaptiveLock
+	PositionerRing                                //ringEntryPosition, cap must be power of two
+	PositionerLockFreeSlots                       //lfSlotsPosition, Treiber stack free list
+	PositionerBitmap                              //bitmapPosition, 1 bit per entry
+)
+
+func (k PositionerKind) String() string {
+	switch k {
+	case PositionerDefault:
+		return "default"
+	case PositionerSlots:
+		return "slots"
+	case PositionerRing:
+		return "ring"
+	case PositionerLockFreeSlots:
+		return "lfslots"
+	case PositionerBitmap:
+		return "bitmap"
+	}
+	return fmt.Sprintf("PositionerKind(%d)", int(k))
+}
+
+func OptionWithPositioner(k PositionerKind) Option {
+	return func(c *CachePoolConf) {
+		c.positioner = k
+	}
+}
+
+//PositionerDefault is slots or ring, by flag useSlots
+func resolvePositioner(k PositionerKind) PositionerKind {
+	if k != PositionerDefault {
+		return k
+	}
+	if useSlots {
+		return PositionerSlots
+	}
+	return PositionerRing
+}
+
+func newPositioner(k PositionerKind) (EntryPositioner, PositionerKind, error) {
+	k = resolvePositioner(k)
+	switch k {
+	case PositionerSlots:
+		return new(slotsPosition), k, nil
+	case PositionerRing:
+		return new(ringEntryPosition), k, nil
+	case PositionerLockFreeSlots:
+		return new(lfSlotsPosition), k, nil
+	case PositionerBitmap:
+		return new(bitmapPosition), k, nil
+	}
+	return nil, k, fmt.Errorf("unknown positioner:%s", k)
+}
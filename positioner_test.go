@@ -0,0 +1,57 @@
+package cachePool
+
+import (
+	"runtime"
+	"testing"
+)
+
+//compare memory and throughput of EntryPositioner kinds:
+//go test -run ^$ -bench BenchmarkPositioner -cpu 1,4,16
+//heap/entry include the Entry in buffer, the rest is metadata of positioner
+func BenchmarkPositioner(b *testing.B) {
+	for _, kind := range []PositionerKind{PositionerSlots, PositionerRing, PositionerLockFreeSlots, PositionerBitmap} {
+		b.Run(kind.String(), func(b *testing.B) {
+			benchmarkPositioner(b, kind, 1<<20, 4)
+		})
+	}
+}
+
+func heapAlloc() uint64 {
+	var ms runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+//every goroutine hold up to hold values before put them back, poolCap must be power of two for ring
+func benchmarkPositioner(b *testing.B, kind PositionerKind, poolCap, hold int) {
+	before := heapAlloc()
+	cp, err := NewCachePool(1, poolCap, OptionWithPositioner(kind), OptionWithAutoExtend(false))
+	if err != nil {
+		b.Fatalf("NewCachePool: %v", err)
+	}
+	heap := heapAlloc() - before
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		held := make([]*Value, 0, hold)
+		for pb.Next() {
+			if v := cp.GetValue(); v != nil {
+				held = append(held, v)
+			}
+			if len(held) == cap(held) {
+				for _, v := range held {
+					cp.PutValue(v)
+				}
+				held = held[:0]
+			}
+		}
+		for _, v := range held {
+			cp.PutValue(v)
+		}
+	})
+	b.StopTimer()
+	//ResetTimer clear the metrics reported before it
+	b.ReportMetric(float64(heap)/float64(cp.Capacity()), "heap/entry")
+	runtime.KeepAlive(cp)
+}
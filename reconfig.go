@@ -0,0 +1,95 @@
+package cachePool
+
+import "fmt"
+
+/*
+runtime reconfiguration: 服务会运行几个月，改扩展的限制不应该要重启。
+能在运行时修改的配置放在liveConf 里，Reconfigure 生成一个新的liveConf 用atomic.Pointer 发布，
+GetValue/GetBytes 扩展时只Load 一次指针，不加锁，一次扩展里看到的配置是一致的。
+	可以修改: autoExtend, maxPool, maxCapacity, memoryLimit, growth, logLevel (只是这个cachePool 的)
+	          shardSize 变了就调用Reshard, 等key 迁移完才返回
+	不能修改: poolNum, poolCap, positioner, localCacheSize, numa, sizeClasses, leakTracking,
+	          它们决定了已经建好的pool、magazine、shard map 的结构，Reconfigure 对这些字段返回FieldError。
+调小maxPool/maxCapacity/memoryLimit 不会释放已有的pool, 只是不再扩展，释放用Compact.
+*/
+
+//settings which can change at runtime, it is never modified after published
+type liveConf struct {
+	autoExtend  bool
+	maxPool     int
+	maxCapacity int
+	memoryLimit int64
+	growth      GrowthPolicy //nil means FixedStepGrowth{}
+	logLevel    LogLevel     //LogDefault means the global one, see log.go
+}
+
+func (c *CachePoolConf) liveConf() *liveConf {
+	return &liveConf{
+		autoExtend:  c.autoExtend,
+		maxPool:     c.maxPool,
+		maxCapacity: c.maxCapacity,
+		memoryLimit: c.memoryLimit,
+		growth:      c.growth,
+		logLevel:    c.logLevel,
+	}
+}
+
+func (cp *cachePool) live() *liveConf {
+	return cp.liveConfig.Load()
+}
+
+//Reconfigure apply the settings of cfg which can change at runtime, cfg must be valid and
+//the other settings must be the same as the cachePool was created with, or ConfigErrors is returned
+//and nothing is changed. the GrowthPolicy set by OptionWithGrowthPolicy is replaced by cfg.Growth
+func (cp *cachePool) Reconfigure(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := cp.checkFixed(&cfg); err != nil {
+		return err
+	}
+	growth, _ := cfg.Growth.GrowthPolicy()
+	level, _ := ParseLogLevel(cfg.LogLevel)
+	cp.liveConfig.Store(&liveConf{
+		autoExtend:  cfg.autoExtend(),
+		maxPool:     cfg.MaxPool,
+		maxCapacity: cfg.MaxCapacity,
+		memoryLimit: cfg.MemoryLimit,
+		growth:      growth,
+		logLevel:    level,
+	})
+	if shardSize := cfg.shardSize(); CeilToPowerOfTwo(shardSize) != cp.ShardSize() {
+		if err := cp.Reshard(shardSize); err != nil {
+			return err
+		}
+	}
+	cp.info("reconfigure: autoExtend:%v, maxPool:%d, maxCapacity:%d, memoryLimit:%d, growth:%+v, logLevel:%s\n",
+		cfg.autoExtend(), cfg.MaxPool, cfg.MaxCapacity, cfg.MemoryLimit, cfg.Growth, level)
+	return nil
+}
+
+//check the settings which can't change at runtime
+func (cp *cachePool) checkFixed(cfg *Config) error {
+	var es ConfigErrors
+	fixed := func(field string, v interface{}, changed bool, now interface{}) {
+		if changed {
+			es = append(es, &FieldError{Field: field, Value: v,
+				Reason: fmt.Sprintf("can't be changed at runtime, it is %v", now)})
+		}
+	}
+	kind, _ := ParsePositionerKind(cfg.Positioner)
+	sc := cfg.SizeClasses
+
+	fixed("poolNum", cfg.PoolNum, cfg.PoolNum != cp.poolNum, cp.poolNum)
+	fixed("poolCap", cfg.PoolCap, cfg.PoolCap != cp.poolCap, cp.poolCap)
+	fixed("positioner", cfg.Positioner, resolvePositioner(kind) != resolvePositioner(cp.positioner), resolvePositioner(cp.positioner))
+	fixed("localCacheSize", cfg.LocalCacheSize, cfg.LocalCacheSize != cp.localCacheSize, cp.localCacheSize)
+	fixed("numa", cfg.NUMA, cfg.NUMA != cp.numa, cp.numa)
+	fixed("sizeClasses", sc, sc.Min != cp.classMin || sc.Max != cp.classMax || sc.PoolCap != cp.classPoolCap,
+		SizeClassConfig{Min: cp.classMin, Max: cp.classMax, PoolCap: cp.classPoolCap})
+	fixed("leakTracking", cfg.LeakTracking, cfg.LeakTracking != cp.leakTracking, cp.leakTracking)
+	if len(es) != 0 {
+		return es
+	}
+	return nil
+}
@@ -1,3 +1,4 @@
+//go:build !ver2
 // +build !ver2
 
 package cachePool
@@ -28,14 +29,17 @@ func (r *ringEntryPosition) InitPosition(buffer []byte, poolIndex, cap, entrySiz
 		return fmt.Errorf("cap must IsPowerOfTwo")
 	}
 	r.ring = make([]EntryHeader, cap)
+	for i := range r.ring {
+		r.ring[i].nextFree = uint32(i) //turn of the first put
+	}
 	for i := 0; i < cap; i++ {
 		eh := (*EntryHeader)(unsafe.Pointer(&buffer[i*entrySize]))
 		eh.poolId = uint32(poolIndex)
-		eh.entryId = uint32(i * entrySize)
-		// in buffer , eh.nextFree is unused, but in ring entryheader, eh.nextFree is used to available or unavailable
+		eh.entryId = uint32(i)
+		// in buffer , eh.nextFree is unused, but in ring entryheader, eh.nextFree is the turn of the slot
 		r.PutEntryHeader(eh)
 	}
-	fmt.Printf("poolId:%d, initRingPosition:%s", poolIndex, r)
+	debug("poolId:%d, initRingPosition:%s", poolIndex, r)
 	return nil
 }
 
@@ -58,7 +62,7 @@ func (r *ringEntryPosition) String() string {
 	for i := uint32(0); i < n; i++ {
 		index := (tail + i) & mask
 		s += fmt.Sprintf("i:%d, poolId:%d, entryId:%d, a:%v\n", i,
-			r.ring[index].poolId, r.ring[index].entryId, r.ring[index].nextFree == Available)
+			r.ring[index].poolId, r.ring[index].entryId, r.ring[index].nextFree == tail+i+1)
 	}
 	return s
 }
@@ -83,11 +87,20 @@ put 线程1：								    put 线程2：
 ```
 */
 
+/*
+slot turn: CAS headtail 只是预留了位置pos, 之后才读写slot, 所以同一个slot 可能同时被两圈的put 预留:
+put A 预留了pos, 还没写slot, get 已经把tail 推过pos 在等slot, put B 就可以预留pos+cap.
+只用Available/Unavailable 的话A 和B 都看到Unavailable, 会同时写slot, entry 丢失或者被分配两次。
+所以slot 的nextFree 存的是turn (uint32 环绕没关系, cap 是2的幂):
+	pos 的put 等到nextFree == pos 才写slot, 写完设为pos+1
+	pos 的get 等到nextFree == pos+1 才读slot, 读完设为pos+cap, 即下一圈put 的turn
+*/
+
 //把head 和 tail 放在一个atomic 操作里，可保证没有问题，但是性能会差些, 因为读写线程都需要操作一个uint64 headtail
 //把head 和 tail 放在一个atomic 操作里，可满足从head pop 即head值减小的情况，比如 1.13 sync.pool getSlow()的实现
 func (r *ringEntryPosition) PutEntryHeader(eh *EntryHeader) bool {
 	cap := uint32(len(r.ring))
-	n, index := uint32(0), uint32(0)
+	n := uint32(0)
 	// in for loop, shouldn't be sched
 	procPin()
 	defer procUnpin()
@@ -109,48 +122,111 @@ func (r *ringEntryPosition) PutEntryHeader(eh *EntryHeader) bool {
 			continue
 		}
 
-		index = head & (cap - 1)
-		for {
-			if atomic.LoadUint32(&r.ring[index].nextFree) == Unavailable {
-				eh.nextFree = Unavailable //set eh to unavailable.
-				r.ring[index] = *eh       //eh.nextFree == 0（Unavailable）, so get goroutine still can't get it
-				//如果没有设置eh.nextFree = 0 ，可能此时get goroutine 就可以读取到entryHeader，并且设置nextFree=0,
-				//然后继续这里的流程执行atomic.StoreUint32(&p.ring[index].nextFree, head+1)，此slot 将永远不可Put
-				//要保证此put函数执行完，get goroutine 才能看到此slot 可用
-				atomic.StoreUint32(&r.ring[index].nextFree, Available) // get goroutine will check it is available
-				return true
-			}
-			r.incPutRace()
-		}
+		eh.nextFree = Unavailable //set eh to unavailable.
+		r.putSlot(head, eh.entryPosition)
+		return true
 	}
 }
 
-func (r *ringEntryPosition) GetEntryHeader() EntryHeader {
-	var eh *EntryHeader
-	ret := InvalidEntryHeader
+//wait for the turn of put at pos, fill the slot and make it the turn of get
+func (r *ringEntryPosition) putSlot(pos uint32, ep entryPosition) {
+	slot := &r.ring[pos&uint32(len(r.ring)-1)]
+	for atomic.LoadUint32(&slot.nextFree) != pos {
+		r.incPutRace()
+		spinYield()
+	}
+	slot.entryPosition = ep //get goroutine can't read it until the store below
+	atomic.StoreUint32(&slot.nextFree, pos+1)
+}
+
+//wait for the turn of get at pos, read the slot and make it the turn of put in next lap
+func (r *ringEntryPosition) getSlot(pos uint32) EntryHeader {
 	cap := uint32(len(r.ring))
+	slot := &r.ring[pos&(cap-1)]
+	for atomic.LoadUint32(&slot.nextFree) != pos+1 {
+		r.incGetRace()
+		spinYield()
+	}
+	eh := EntryHeader{entryPosition: slot.entryPosition, nextFree: Available}
+	atomic.StoreUint32(&slot.nextFree, pos+cap)
+	return eh
+}
+
+func (r *ringEntryPosition) GetEntryHeader() EntryHeader {
 	for {
 		headtail := atomic.LoadUint64(&r.headtail)
 		head, tail := unpack(headtail)
 
 		if head == tail {
-			return ret
+			return InvalidEntryHeader
 		}
 		newheadtail := uint64(head)<<32 | uint64(tail+1)
 		if atomic.CompareAndSwapUint64(&r.headtail, headtail, newheadtail) {
-			eh = &r.ring[tail&(cap-1)]
-			for {
-				if atomic.LoadUint32(&eh.nextFree) == Available {
-					ret = *eh
-					atomic.StoreUint32(&eh.nextFree, Unavailable)
-					return ret
-				}
-				r.incGetRace()
-			}
+			return r.getSlot(tail)
 		}
 		r.incGetRace()
 	}
-	return ret
+}
+
+//reserve min(len(ehs), free room) ring slots with one CAS, then fill them one by one
+func (r *ringEntryPosition) PutEntryHeaders(ehs []*EntryHeader) int {
+	cap := uint32(len(r.ring))
+	k := uint32(0)
+	procPin()
+	defer procUnpin()
+	for {
+		headtail := atomic.LoadUint64(&r.headtail)
+		head, tail := unpack(headtail)
+
+		n := head - tail
+		if n > cap {
+			fmt.Printf("headtail=%d, head=%d, tail=%d, n=%d, cap=%d\n", headtail, head, tail, n, cap)
+			panic("int(n) > cap")
+		}
+		k = cap - n
+		if k > uint32(len(ehs)) {
+			k = uint32(len(ehs))
+		}
+		if k == 0 {
+			return 0
+		}
+		newheadtail := uint64(head+k)<<32 | uint64(tail)
+		if !atomic.CompareAndSwapUint64(&r.headtail, headtail, newheadtail) {
+			r.incPutRace()
+			continue
+		}
+
+		for i := uint32(0); i < k; i++ {
+			ehs[i].nextFree = Unavailable
+			r.putSlot(head+i, ehs[i].entryPosition)
+		}
+		return int(k)
+	}
+}
+
+//take min(len(dst), available) ring slots with one CAS
+func (r *ringEntryPosition) GetEntryHeaders(dst []EntryHeader) int {
+	for {
+		headtail := atomic.LoadUint64(&r.headtail)
+		head, tail := unpack(headtail)
+
+		k := head - tail
+		if k == 0 {
+			return 0
+		}
+		if k > uint32(len(dst)) {
+			k = uint32(len(dst))
+		}
+		newheadtail := uint64(head)<<32 | uint64(tail+k)
+		if !atomic.CompareAndSwapUint64(&r.headtail, headtail, newheadtail) {
+			r.incGetRace()
+			continue
+		}
+		for i := uint32(0); i < k; i++ {
+			dst[i] = r.getSlot(tail + i)
+		}
+		return int(k)
+	}
 }
 
 func (r *ringEntryPosition) incPutRace() {
@@ -1,3 +1,4 @@
+//go:build ver2
 // +build ver2
 
 package cachePool
@@ -29,7 +30,7 @@ func (r *ringEntryPosition2) InitPosition(buffer []byte, poolIndex, cap, entrySi
 	for i := 0; i < cap; i++ {
 		eh := (*EntryHeader)(unsafe.Pointer(&buffer[i*entrySize]))
 		eh.poolId = uint32(poolIndex)
-		eh.entryId = uint32(i * entrySize)
+		eh.entryId = uint32(i)
 		// in buffer , eh.nextFree is unused, but in ring entryheader, eh.nextFree is used to available or unavailable
 		r.PutEntry(eh)
 	}
@@ -0,0 +1,105 @@
+package cachePool
+
+import (
+	"sync/atomic"
+)
+
+//PoolSelector chose the pool which GetValue start probing from, and the pool the per-P
+//magazine is refilled from, if the pool has no free entry, the next pools are probed round-robin.
+//hasKey is true when called by GetValueForKey, then hint is the key's hash (it may be 0),
+//otherwise there is no key and hint is meaningless
+type PoolSelector interface {
+	SelectPool(pools []*Pool, hint uint64, hasKey bool) int
+}
+
+//PerPSelector start from the pool of current P, like syncPool, it is the default
+type PerPSelector struct{}
+
+func (PerPSelector) SelectPool(pools []*Pool, hint uint64, hasKey bool) int {
+	return getPid()
+}
+
+//RoundRobinSelector start from the next pool every time
+type RoundRobinSelector struct {
+	next uint32
+}
+
+func (s *RoundRobinSelector) SelectPool(pools []*Pool, hint uint64, hasKey bool) int {
+	return int(atomic.AddUint32(&s.next, 1) - 1)
+}
+
+//HashSelector start from the pool chosen by the key's hash,
+//so values of the same tenant/flow are in the same pool.
+//without key, it start from the pool of current P like PerPSelector,
+//otherwise all GetValue and magazine refills would go to pool 0
+type HashSelector struct{}
+
+func (HashSelector) SelectPool(pools []*Pool, hint uint64, hasKey bool) int {
+	if !hasKey {
+		return getPid()
+	}
+	return int(hint % uint64(len(pools)))
+}
+
+//LeastUsedSelector start from the pool with the lowest usage ratio
+type LeastUsedSelector struct{}
+
+func (LeastUsedSelector) SelectPool(pools []*Pool, hint uint64, hasKey bool) int {
+	best, bestRatio := 0, 2.0
+	for i, p := range pools {
+		if p == nil || p.Cap() == 0 {
+			continue
+		}
+		ratio := float64(p.Used()) / float64(p.Cap())
+		if ratio < bestRatio {
+			best, bestRatio = i, ratio
+		}
+	}
+	return best
+}
+
+func OptionWithPoolSelector(s PoolSelector) Option {
+	return func(c *CachePoolConf) {
+		c.selector = s
+	}
+}
+
+func (cp *cachePool) selectPool(pools []*Pool, hint uint64, hasKey bool) int {
+	if len(pools) == 0 {
+		return 0
+	}
+	i := cp.selector.SelectPool(pools, hint, hasKey) % len(pools)
+	if i < 0 {
+		i += len(pools)
+	}
+	return i
+}
+
+//GetValueForKey is like GetValue, but give the key's hash to PoolSelector as hint,
+//it doesn't use the per-P local cache, so the value is from the pool chosen by PoolSelector
+func (cp *cachePool) GetValueForKey(key Key) *Value {
+	return cp.getValue(uint64(key.Hash()), true)
+}
+
+//GetValueFrom get a free value from the pool of poolIndex only, it doesn't extend
+//the cachePool, return nil if the pool is not exist or has no free entry
+func (cp *cachePool) GetValueFrom(poolIndex int) *Value {
+	pools := cp.loadPools()
+	if poolIndex < 0 || poolIndex >= len(pools) || pools[poolIndex] == nil {
+		return nil
+	}
+	entry := pools[poolIndex].GetEntry()
+	if entry == nil {
+		return nil
+	}
+	return &entry.Value
+}
+
+//Used return the num of entries taken from the pool's positioner
+func (p *Pool) Used() uint32 {
+	return uint32(atomic.LoadInt64(&p.used))
+}
+
+func (p *Pool) addUsed(n int) {
+	atomic.AddInt64(&p.used, int64(n))
+}
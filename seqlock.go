@@ -0,0 +1,80 @@
+package cachePool
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+seqlock: Value 有多个字段，Load 之后直接读，可能读到并发写了一半的value.
+Write 在entry lock 保护下，先把seq 变成奇数，写value，再把seq 变成偶数；
+ReadConsistent 不加锁，读value 前后seq 相同且是偶数才说明读到的是一个完整的value，否则重试。
+value 是按word 原子读写的，所以用ReadConsistent 读的value，只能用Write 修改。
+*/
+const valueWords = unsafe.Sizeof(Value{}) / unsafe.Sizeof(uintptr(0))
+
+func init() {
+	if unsafe.Sizeof(Value{})%unsafe.Sizeof(uintptr(0)) != 0 {
+		panic(fmt.Sprintf("size of Value:%d must be multiple of %d", unsafe.Sizeof(Value{}), unsafe.Sizeof(uintptr(0))))
+	}
+}
+
+func loadValue(v *Value) (val Value) {
+	src := (*[valueWords]uintptr)(unsafe.Pointer(v))
+	dst := (*[valueWords]uintptr)(unsafe.Pointer(&val))
+	for i := range src {
+		dst[i] = atomic.LoadUintptr(&src[i])
+	}
+	return
+}
+
+func storeValue(v *Value, val *Value) {
+	src := (*[valueWords]uintptr)(unsafe.Pointer(val))
+	dst := (*[valueWords]uintptr)(unsafe.Pointer(v))
+	for i := range src {
+		atomic.StoreUintptr(&dst[i], src[i])
+	}
+}
+
+//ReadConsistent call f with a copy of the value of key which is not torn by Write,
+//it doesn't lock, just retry if there is a concurrent Write. return false if key is not exist.
+//if the value may be freed at the same time, use Acquire instead
+func (cp *cachePool) ReadConsistent(key Key, f func(Value)) bool {
+	v := cp.Load(key)
+	if v == nil {
+		return false
+	}
+	e := GetEntryFromElem(v)
+	for i := 0; ; i++ {
+		seq := atomic.LoadUint32(&e.seq)
+		if seq&1 == 0 {
+			val := loadValue(v)
+			if atomic.LoadUint32(&e.seq) == seq {
+				f(val)
+				return true
+			}
+		}
+		spinWait(i)
+	}
+}
+
+//Write run f on a copy of the value of key, then publish it with the seq counter,
+//writers are serialized by the entry lock. return false if key is not exist.
+//if f panic, the value is not changed, the lock and lease are released
+func (cp *cachePool) Write(key Key, f func(*Value)) bool {
+	v, release := cp.Acquire(key)
+	if v == nil {
+		return false
+	}
+	defer release()
+	e := GetEntryFromElem(v)
+	cp.LockValue(v)
+	defer cp.UnlockValue(v)
+	val := loadValue(v)
+	f(&val)
+	atomic.AddUint32(&e.seq, 1)       //odd, writing
+	defer atomic.AddUint32(&e.seq, 1) //even again, before unlock even if it panic
+	storeValue(v, &val)
+	return true
+}
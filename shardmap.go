@@ -0,0 +1,138 @@
+package cachePool
+
+import "fmt"
+
+/*
+reshard: shard 数在NewShardMap 时就定了, key 从几千涨到几千万时shard 太少，每个map 都很大，
+map 扩容时一次要搬很多bucket. Reshard(n) 在线把key 从旧的maps 迁移到新的一组maps:
+	1. sm.Lock 下把maps 换成新的空maps, 旧的放到old
+	2. 每次sm.Lock 下只迁移reshardBatch 个key, 然后Unlock, 让Store/Load 可以继续
+	3. 迁移期间: Load 先查新maps, 没有再查old (dual-read); Store 写新maps 并删掉old 里的;
+	   Delete 两边都删, 所以一个key 不会同时在新旧maps 里
+	4. old 都空了, old = nil, 迁移结束
+所有访问都通过get/set/del/rangeAll, 必须持有sm 的锁, 因为maps 和shardMask 会被Reshard 修改。
+*/
+
+const (
+	MaxShardSize = 1 << 16
+	reshardBatch = 1024 //keys migrated in one sm.Lock
+)
+
+//called with sm.RLock or sm.Lock held
+func (sm *poolShardMap) get(k *Key) (uint64, bool) {
+	hash := k.Hash()
+	elemID, ok := sm.maps[hash&sm.shardMask][*k]
+	if !ok && sm.old != nil {
+		elemID, ok = sm.old[hash&sm.oldMask][*k]
+	}
+	return elemID, ok
+}
+
+//called with sm.Lock held
+func (sm *poolShardMap) set(k *Key, elemID uint64) {
+	hash := k.Hash()
+	sm.maps[hash&sm.shardMask][*k] = elemID
+	if sm.old != nil {
+		delete(sm.old[hash&sm.oldMask], *k)
+	}
+}
+
+//called with sm.Lock held
+func (sm *poolShardMap) del(k *Key) {
+	hash := k.Hash()
+	delete(sm.maps[hash&sm.shardMask], *k)
+	if sm.old != nil {
+		delete(sm.old[hash&sm.oldMask], *k)
+	}
+}
+
+//called with sm.RLock or sm.Lock held, f must not modify sm
+func (sm *poolShardMap) rangeAll(f func(k Key, elemID uint64)) {
+	for _, m := range sm.maps {
+		for k, elemID := range m {
+			f(k, elemID)
+		}
+	}
+	for _, m := range sm.old {
+		for k, elemID := range m {
+			f(k, elemID)
+		}
+	}
+}
+
+func (sm *poolShardMap) size() int {
+	sm.RLock()
+	defer sm.RUnlock()
+	return sm.shardSize
+}
+
+//ShardSize return the num of shards of key index, it is power of two
+func (cp *cachePool) ShardSize() int {
+	return cp.sm.size()
+}
+
+func makeShards(n int) []map[Key]uint64 {
+	maps := make([]map[Key]uint64, n)
+	for i := range maps {
+		maps[i] = make(map[Key]uint64)
+	}
+	return maps
+}
+
+//Reshard change the num of shards of key index to n (rounded up to power of two), keys are
+//migrated batch by batch, Store/Load/Delete keep working meanwhile. it return after migration
+func (cp *cachePool) Reshard(n int) error {
+	return cp.sm.reshard(n)
+}
+
+func (sm *poolShardMap) reshard(n int) error {
+	if n <= 0 || n > MaxShardSize {
+		return fmt.Errorf("shard num:%d out of range [1, %d]", n, MaxShardSize)
+	}
+	sm.reshardMu.Lock()
+	defer sm.reshardMu.Unlock()
+
+	size := CeilToPowerOfTwo(n)
+	sm.Lock()
+	sm.origSize = n
+	if size == sm.shardSize {
+		sm.Unlock()
+		return nil
+	}
+	sm.old, sm.oldMask = sm.maps, sm.shardMask
+	sm.maps = makeShards(size)
+	sm.shardSize, sm.shardMask = size, size-1
+	sm.Unlock()
+
+	moved := 0
+	for i := range sm.old {
+		for done := false; !done; {
+			sm.Lock()
+			done = sm.migrate(sm.old[i], reshardBatch, &moved)
+			sm.Unlock()
+		}
+	}
+	sm.Lock()
+	sm.old, sm.oldMask = nil, 0
+	sm.Unlock()
+	info("reshard: shard num:%d, keys moved:%d\n", size, moved)
+	return nil
+}
+
+//called with sm.Lock held, move up to batch keys from m to the new maps, return true if m is empty
+func (sm *poolShardMap) migrate(m map[Key]uint64, batch int, moved *int) bool {
+	for k, elemID := range m {
+		if batch == 0 {
+			return false
+		}
+		batch--
+		nm := sm.maps[k.Hash()&sm.shardMask]
+		//Store to new maps have deleted it from old, but check it anyway
+		if _, ok := nm[k]; !ok {
+			nm[k] = elemID
+		}
+		delete(m, k)
+		*moved++
+	}
+	return true
+}
@@ -0,0 +1,324 @@
+package cachePool
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+size class pools, like slab allocator:
+Value pool 的entrySize 固定是sizeof(Entry)，不能缓存变长的数据，所以按size class (64, 128, 256 ...)
+分别建立一组pool，GetBytes(n) 从刚好能容纳n 字节的size class 里取一个entry，返回entry里的[]byte,
+[]byte 不包含指针，gc 不会扫描。
+
+bytes entry layout:
+	| EntryHeader | the other fields of Entry | n uint32 | pad to 8 | payload (class size) |
+GetEntry、lease、Compact 等会把entry 当作*Entry, 所以n 之前的字段和Entry 一样，
+n 放在Entry.Value 的offset, 不依赖Entry.Value 之前的padding (32 位平台上没有padding),
+size class pool 的entry 不会被当作Value 读写, 所以n 和payload 覆盖Entry.Value 没关系。
+
+poolId of size class pool: (class+1)<<classShift | pool index, so it never conflicts
+with the Value pool's poolId, and the elemID stored in poolShardMap can tell which pool it belongs to.
+*/
+
+const (
+	classShift   = 24
+	classIdxMask = 1<<classShift - 1
+	maxClassNum  = IdMask >> classShift
+	maxClassSize = 1 << 30 //len of payload is uint32
+)
+
+type bytesHeader struct {
+	EntryHeader
+	_ [unsafe.Offsetof(Entry{}.Value) - unsafe.Sizeof(EntryHeader{})]byte //the other fields of Entry, bytes never use them
+	n uint32                                                              //len of payload, at the offset of Entry.Value
+}
+
+//offset of payload in entry, 8 bytes aligned on all platforms
+const bytesOffset = (unsafe.Sizeof(bytesHeader{}) + 7) &^ 7
+
+type sizeClass struct {
+	sync.Mutex //for extension
+	class      int
+	size       int //payload size
+	entrySize  int
+	kind       PositionerKind
+	table      atomic.Pointer[poolTable] //pools of this class, see pooltable.go
+}
+
+func (c *sizeClass) loadPools() []*Pool {
+	return c.table.Load().pools
+}
+
+//OptionWithSizeClasses make size class pools, size classes are power of two from min to max,
+//the first pool of size class has poolCap entries, the pools added later follow GrowthPolicy
+func OptionWithSizeClasses(min, max, poolCap int) Option {
+	return func(c *CachePoolConf) {
+		c.classMin = min
+		c.classMax = max
+		c.classPoolCap = poolCap
+	}
+}
+
+func (cp *cachePool) initSizeClasses() error {
+	if cp.classMax == 0 {
+		return nil
+	}
+	//min, max and poolCap have been checked by Check
+	var err error
+	LogarithmicRange(cp.classMin, CeilToPowerOfTwo(cp.classMax), func(size int) {
+		if err != nil {
+			return
+		}
+		if len(cp.classes) == maxClassNum {
+			err = fmt.Errorf("too many size classes, max is %d", maxClassNum)
+			return
+		}
+		c := &sizeClass{class: len(cp.classes), size: size, kind: cp.positioner}
+		c.table.Store(&poolTable{})
+		c.entrySize = classEntrySize(size)
+		cp.classes = append(cp.classes, c)
+		if cp.reserveMemory(cp.live(), cp.classPoolCap, c.entrySize, false) == 0 {
+			err = ErrMemoryLimit
+			return
+		}
+		if _, err = c.newPool(cp, cp.classPoolCap); err != nil {
+			cp.releaseMemory(cp.classPoolCap, c.entrySize)
+		}
+	})
+	return err
+}
+
+func classEntrySize(size int) int {
+	n := int(bytesOffset) + (size+7)&^7
+	if n < entrySize {
+		//Pool.GetEntry() cast it to *Entry
+		n = entrySize
+	}
+	return n
+}
+
+func classPoolId(class, index int) int {
+	return (class+1)<<classShift | index
+}
+
+//return class and pool index of poolId, class is -1 if it is Value pool
+func splitPoolId(poolId uint32) (int, int) {
+	return int(poolId>>classShift) - 1, int(poolId & classIdxMask)
+}
+
+//must be called with c.Lock() held, or at init
+func (c *sizeClass) newPool(cp *cachePool, cap int) (*Pool, error) {
+	t := c.table.Load()
+	index := len(t.pools)
+	if index > classIdxMask {
+		return nil, fmt.Errorf("size class %d have too many pools", c.size)
+	}
+	p, err := newPool(classPoolId(c.class, index), cap, c.entrySize, c.kind)
+	if err != nil {
+		return nil, err
+	}
+	cp.addSpan(p)
+	c.table.Store(t.with(index, p))
+	return p, nil
+}
+
+func (cp *cachePool) sizeClassOf(n int) *sizeClass {
+	for _, c := range cp.classes {
+		if n <= c.size {
+			return c
+		}
+	}
+	return nil
+}
+
+func (c *sizeClass) getEntry(cp *cachePool) *Entry {
+	for {
+		pools := c.loadPools()
+		max := len(pools)
+		start := getPid()
+		for i := 0; i < max; i++ {
+			if e := pools[(start+i)%max].GetEntry(); e != nil {
+				return e
+			}
+		}
+		l := cp.live()
+		if !l.autoExtend {
+			return nil
+		}
+		c.Lock()
+		if max < len(c.loadPools()) { //have apppend new pool
+			c.Unlock()
+			continue
+		}
+		if l.maxPool != 0 && len(c.loadPools()) >= l.maxPool {
+			c.Unlock()
+			return nil
+		}
+		cap := cp.nextPoolCap(l, c.loadPools(), cp.classPoolCap)
+		if cap > 0 {
+			cap = cp.reserveMemory(l, cap, c.entrySize, true)
+		}
+		if cap <= 0 {
+			c.Unlock()
+			return nil
+		}
+		p, err := c.newPool(cp, cap)
+		if err != nil {
+			cp.releaseMemory(cap, c.entrySize)
+			c.Unlock()
+			return nil
+		}
+		e := p.GetEntry()
+		c.Unlock()
+		cp.info("add new pool to size class:%d, pool num:%d\n", c.size, len(c.loadPools()))
+		if e == nil {
+			//new pool is published before GetEntry, other goroutines may take all its entries
+			continue
+		}
+		return e
+	}
+}
+
+func (cp *cachePool) getClassPool(poolId uint32) *Pool {
+	class, index := splitPoolId(poolId)
+	if class < 0 || class >= len(cp.classes) {
+		return nil
+	}
+	return cp.classes[class].table.Load().get(uint32(index))
+}
+
+//GetBytes return a byte slice with len n and cap of the size class from size class pools,
+//it is nil if n is larger than the max size class or no free entry.
+//the byte slice contains no pointer, don't reslice it from the start before PutBytes or StoreBytes
+func (cp *cachePool) GetBytes(n int) []byte {
+	if n < 0 {
+		return nil
+	}
+	c := cp.sizeClassOf(n)
+	if c == nil {
+		return nil
+	}
+	e := c.getEntry(cp)
+	if e == nil {
+		return nil
+	}
+	h := (*bytesHeader)(unsafe.Pointer(e))
+	h.n = uint32(n)
+	p := cp.getClassPool(e.poolId)
+	return bytesOfHeader(p, h, n)
+}
+
+func bytesOfHeader(p *Pool, h *bytesHeader, n int) []byte {
+	off := p.entryOffset(h.entryId)
+	start := off + int(bytesOffset)
+	return p.buffer[start : start+n : off+p.entrySize]
+}
+
+//bytesEntry return the header of b got by GetBytes, ErrForeignPointer if b doesn't start at
+//the payload of an entry of size class pools, or is resliced from the start.
+//it only compares the address of b, never dereference it before the check pass
+func (cp *cachePool) bytesEntry(b []byte) (*Pool, *bytesHeader, error) {
+	if cap(b) == 0 {
+		return nil, nil, ErrForeignPointer
+	}
+	addr := uintptr(unsafe.Pointer(&b[:1][0])) - bytesOffset
+	p, off := cp.poolOfAddr(addr)
+	if p == nil || p.isValuePool() || off%uintptr(p.entrySize) != 0 || cap(b) != p.entrySize-int(bytesOffset) {
+		return nil, nil, ErrForeignPointer
+	}
+	return p, (*bytesHeader)(unsafe.Pointer(p.entryAt(uint32(off / uintptr(p.entrySize))))), nil
+}
+
+//PutBytes put the byte slice got by GetBytes back to its size class pool,
+//it return ErrForeignPointer or ErrDoubleFree and never touch the memory if b is invalid,
+//ErrPutFailed means the pool refuse it, b is lost
+func (cp *cachePool) PutBytes(b []byte) error {
+	p, h, err := cp.bytesEntry(b)
+	if err != nil {
+		atomic.AddUint64(&cp.foreignPuts, 1)
+		return err
+	}
+	return cp.putBytesEntry(p, (*Entry)(unsafe.Pointer(h)))
+}
+
+func (cp *cachePool) putBytesEntry(p *Pool, e *Entry) error {
+	if !e.clearUsed() {
+		atomic.AddUint64(&cp.doubleFrees, 1)
+		return ErrDoubleFree
+	}
+	if !p.PutEntry(e) {
+		return ErrPutFailed
+	}
+	return nil
+}
+
+//StoreBytes store the byte slice got by GetBytes, len(b) is recorded, so LoadBytes
+//return the slice with the same len, it is the same as StoreHandle(key, BytesHandle(b))
+func (cp *cachePool) StoreBytes(key Key, b []byte) error {
+	_, h, err := cp.bytesEntry(b)
+	if err != nil {
+		return err
+	}
+	if !h.isUsed() {
+		return ErrDoubleFree
+	}
+	h.n = uint32(len(b))
+	cp.sm.Lock()
+	cp.sm.set(&key, h.elemID())
+	cp.sm.Unlock()
+	return nil
+}
+
+//LoadBytes return the byte slice stored by StoreBytes, nil if not exist or it is a Value
+func (cp *cachePool) LoadBytes(key Key) []byte {
+	h, ok := cp.LoadHandle(key)
+	if !ok {
+		return nil
+	}
+	return cp.HandleBytes(h)
+}
+
+func (cp *cachePool) getBytesFromElemID(elemID uint64) []byte {
+	entryh := (*EntryHeader)(unsafe.Pointer(&elemID))
+	p := cp.getClassPool(entryh.poolId)
+	if p == nil {
+		return nil
+	}
+	if p.invalid(entryh.entryId) {
+		return nil
+	}
+	h := (*bytesHeader)(unsafe.Pointer(p.entryAt(entryh.entryId)))
+	return bytesOfHeader(p, h, int(h.n))
+}
+
+//DeleteAndFreeBytes delete key and put its byte slice back, it return false and keep key
+//if key is not exist or it is stored by Store
+func (cp *cachePool) DeleteAndFreeBytes(key Key) bool {
+	cp.sm.Lock()
+	elemID, ok := cp.sm.get(&key)
+	ok = ok && isBytesElemID(elemID)
+	if ok {
+		cp.sm.del(&key)
+	}
+	cp.sm.Unlock()
+	return ok && cp.freeBytesElemID(elemID)
+}
+
+//elemID is an entry of size class pools, not a Value
+func isBytesElemID(elemID uint64) bool {
+	entryh := (*EntryHeader)(unsafe.Pointer(&elemID))
+	class, _ := splitPoolId(entryh.poolId)
+	return class >= 0
+}
+
+func (cp *cachePool) freeBytesElemID(elemID uint64) bool {
+	entryh := (*EntryHeader)(unsafe.Pointer(&elemID))
+	p := cp.getClassPool(entryh.poolId)
+	if p == nil || p.invalid(entryh.entryId) {
+		return false
+	}
+	return cp.putBytesEntry(p, p.entryAt(entryh.entryId)) == nil
+}
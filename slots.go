@@ -7,7 +7,7 @@ import (
 )
 
 type slotsPosition struct {
-	SpinLock
+	AdaptiveLock //backoff, yield and park under contention, see adaptivelock.go
 	//idleCount uint32
 	idleSlot uint32
 	slots    []EntryHeader //entryheader have no pointer, no scan
@@ -20,7 +20,7 @@ func (s *slotsPosition) InitPosition(buffer []byte, poolIndex, cap, entrySize in
 		for i := 0; i < len(p.slots); i++ {
 			p.Put(uint32(i))
 			p.slots[i].poolId = uint32(p.index)
-			p.slots[i].entryId = uint32(i * entrySize)
+			p.slots[i].entryId = uint32(i)
 			e := (*Entry)(unsafe.Pointer(&p.buffer[i*entrySize]))
 			e.poolId = p.slots[i].poolId
 			e.entryId = p.slots[i].entryId
@@ -32,19 +32,19 @@ func (s *slotsPosition) InitPosition(buffer []byte, poolIndex, cap, entrySize in
 			return fmt.Errorf("put id:%d fail", i)
 		}
 		s.slots[i].poolId = uint32(poolIndex)
-		s.slots[i].entryId = uint32(i * entrySize)
+		s.slots[i].entryId = uint32(i)
 		e := (*Entry)(unsafe.Pointer(&buffer[i*entrySize]))
 		e.poolId = s.slots[i].poolId
 		e.entryId = s.slots[i].entryId
 		e.nextFree = uint32(i) //buffer's EntryHeader's nextFree correspond slot index
 	}
 
-	fmt.Println(s)
+	debug("%s\n", s)
 	return nil
 }
 
 func (s *slotsPosition) String() string {
-	ss := fmt.Sprintf("----Slots, idleSlot=%d-----\n", s.idleSlot)
+	ss := fmt.Sprintf("----Slots, idleSlot=%d, lock:%s-----\n", s.idleSlot, s.Stats())
 	for i, _ := range s.slots {
 		ss += fmt.Sprintf("%s\n", &s.slots[i])
 	}
@@ -110,12 +110,47 @@ func (s *slotsPosition) GetEntryHeader() EntryHeader {
 	id := s.Get()
 	//fmt.Println("id===========", id)
 	if s.invalid(id) {
-		fmt.Printf("slot index=%d invalid\n", id)
+		debug("slot index=%d invalid\n", id)
 		return InvalidEntryHeader
 	}
 	return s.slots[int(id)]
 }
 
+//put many buffer's EntryHeader back with one Lock()
+func (s *slotsPosition) PutEntryHeaders(ehs []*EntryHeader) int {
+	n := 0
+	s.Lock()
+	for _, e := range ehs {
+		e.nextFree &= IdMask
+		id := e.nextFree
+		if s.invalid(id) {
+			continue
+		}
+		atomic.StoreUint32(&s.slots[id].nextFree, atomic.LoadUint32(&s.idleSlot))
+		atomic.StoreUint32(&s.idleSlot, id)
+		n++
+	}
+	s.Unlock()
+	return n
+}
+
+//get many free slots with one Lock()
+func (s *slotsPosition) GetEntryHeaders(dst []EntryHeader) int {
+	n := 0
+	s.Lock()
+	for n < len(dst) {
+		id := atomic.LoadUint32(&s.idleSlot)
+		if s.invalid(id) {
+			break
+		}
+		atomic.StoreUint32(&s.idleSlot, atomic.LoadUint32(&s.slots[id].nextFree))
+		dst[n] = s.slots[int(id)]
+		n++
+	}
+	s.Unlock()
+	return n
+}
+
 // func (p *Pool) GetEntry() *Entry {
 // 	id := p.Get()
 // 	if p.invalid(id) {
@@ -1,8 +1,10 @@
 package cachePool
 
 import (
+	"runtime"
 	"sync/atomic"
-	_ "unsafe"
+
+	"github.com/jursonmo/cachePool/internal/pid"
 )
 
 /*
@@ -19,20 +21,22 @@ func (l *SpinLock) Unlock() {
 */
 
 func getPid() int {
-	pid := procPin()
-	procUnpin()
-	return pid
+	return pid.Get()
 }
 
 type SpinLock struct {
 	lock uint32
 }
 
-//go:linkname procPin runtime.procPin
-func procPin() int
+//procPin is runtime.procPin only when build with tag cachepool_procpin,
+//or it doesn't disable preemption, see internal/pid
+func procPin() int {
+	return pid.Pin()
+}
 
-//go:linkname procUnpin runtime.procUnpin
-func procUnpin()
+func procUnpin() {
+	pid.Unpin()
+}
 
 func NewSpinLock() *SpinLock {
 	return &SpinLock{}
@@ -44,6 +48,7 @@ dead in Lock(), until the first goroutine running on another P and Unlock(), so
 1. make sure between Lokc() and Unlock(), current goroutine don't be scheduled out
 	by using procPin(). eg. check in sync/atomic/value.go
 2. like allenxuxu spinlock, use runtime.Gosched()
+if procPin() can't pin (pid.Pinned is false), use 2.
 */
 func (l *SpinLock) Lock() {
 	procPin()
@@ -53,6 +58,15 @@ func (l *SpinLock) Lock() {
 			return
 		}
 		//procUnpin()
+		spinYield()
+	}
+}
+
+//spinYield let other goroutines run when spinning if current goroutine can't be pinned,
+//the goroutine we are waiting for may have been scheduled out
+func spinYield() {
+	if !pid.Pinned {
+		runtime.Gosched()
 	}
 }
 
@@ -1,5 +1,7 @@
 package cachePool
 
+import "unsafe"
+
 type CachePad struct {
 	padding [8]int64 //for avoid false share
 }
@@ -64,7 +66,7 @@ func fillBits(n int) int {
 	n |= n >> 4
 	n |= n >> 8
 	n |= n >> 16
-	n |= n >> 32
+	n |= n >> (bitsize / 2) //32 on 64 bit platforms, vet reject >>32 on 32 bit ones
 	return n
 }
 
@@ -100,3 +102,8 @@ func (f fnv64a) Sum64(key string) uint64 {
 
 	return hash
 }
+
+//bytesToString convert b to string without copy, b must not be modified while the string is used
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
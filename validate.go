@@ -0,0 +1,200 @@
+package cachePool
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+)
+
+//positionValidator is implemented by EntryPositioner which can check its own consistency,
+//it return the num of free entries it records
+type positionValidator interface {
+	validate(buffer []byte, poolIndex, entrySize int) (int, error)
+}
+
+//Validate walk every pool, check free list/ring of positioner, entry headers and used flags.
+//it should be called when there is no concurrent GetValue/PutValue, or it may report false error
+func (cp *cachePool) Validate() error {
+	//free entries in per-P magazines, key is poolId
+	cached := make(map[uint32]int)
+	for i := range cp.locals {
+		l := &cp.locals[i]
+		for !l.tryLock() {
+		}
+		for j := 0; j < l.n; j++ {
+			e := cp.getEntryFromElemID(l.elems[j])
+			if e == nil {
+				l.unlock()
+				return fmt.Errorf("local cache %d: invalid elemID:%#x", i, l.elems[j])
+			}
+			if e.isUsed() {
+				l.unlock()
+				return fmt.Errorf("local cache %d: %s is used", i, e)
+			}
+			cached[e.poolId]++
+		}
+		l.unlock()
+	}
+
+	pools := append([]*Pool(nil), cp.loadPools()...)
+	for _, c := range cp.classes {
+		pools = append(pools, c.loadPools()...)
+	}
+	for _, p := range pools {
+		if p == nil {
+			continue
+		}
+		if err := p.validate(cached[uint32(p.index)]); err != nil {
+			return fmt.Errorf("pool %d: %v", p.index, err)
+		}
+	}
+	return nil
+}
+
+//cached is the num of free entries of this pool which are not in positioner
+func (p *Pool) validate(cached int) error {
+	used := 0
+	for i := 0; i < int(p.size); i++ {
+		eh := &p.entryAt(uint32(i)).EntryHeader
+		if eh.poolId != uint32(p.index) || eh.entryId != uint32(i) {
+			return fmt.Errorf("entry %d header is corrupted: %s", i, eh)
+		}
+		if atomic.LoadUint32(&eh.nextFree)&UsedFlag != 0 {
+			used++
+		}
+	}
+	pv, ok := p.positioner.(positionValidator)
+	if !ok {
+		return nil
+	}
+	free, err := pv.validate(p.buffer, p.index, p.entrySize)
+	if err != nil {
+		return err
+	}
+	if free+cached+used != int(p.size) {
+		return fmt.Errorf("free:%d + cached:%d + used:%d != cap:%d", free, cached, used, p.size)
+	}
+	if int(p.Used()) != int(p.size)-free {
+		return fmt.Errorf("used counter:%d, but %d entries are out of positioner", p.Used(), int(p.size)-free)
+	}
+	return nil
+}
+
+//check the entry which the positioner think is free
+func checkFreeEntry(buffer []byte, poolIndex, entrySize int, eh *EntryHeader) (int, error) {
+	if eh.poolId != uint32(poolIndex) {
+		return 0, fmt.Errorf("%s: poolId should be %d", eh, poolIndex)
+	}
+	id := int(eh.entryId)
+	if id >= len(buffer)/entrySize {
+		return 0, fmt.Errorf("%s: entryId out of buffer", eh)
+	}
+	e := (*EntryHeader)(unsafe.Pointer(&buffer[id*entrySize]))
+	if atomic.LoadUint32(&e.nextFree)&UsedFlag != 0 {
+		return 0, fmt.Errorf("%s: free entry is used", e)
+	}
+	return id, nil
+}
+
+func (s *slotsPosition) validate(buffer []byte, poolIndex, entrySize int) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	seen := make([]bool, len(s.slots))
+	n := 0
+	id := atomic.LoadUint32(&s.idleSlot)
+	for ; !s.invalid(id); id = atomic.LoadUint32(&s.slots[id].nextFree) {
+		if seen[id] {
+			return n, fmt.Errorf("slot %d is in free list twice", id)
+		}
+		seen[id] = true
+		n++
+		index, err := checkFreeEntry(buffer, poolIndex, entrySize, &s.slots[id])
+		if err != nil {
+			return n, fmt.Errorf("slot %d: %v", id, err)
+		}
+		e := (*EntryHeader)(unsafe.Pointer(&buffer[index*entrySize]))
+		if e.nextFree&IdMask != id {
+			return n, fmt.Errorf("slot %d: entry's slot index is %d", id, e.nextFree&IdMask)
+		}
+	}
+	if id&Invalid == 0 {
+		return n, fmt.Errorf("free list end with slot %d out of range", id)
+	}
+	return n, nil
+}
+
+func (s *lfSlotsPosition) validate(buffer []byte, poolIndex, entrySize int) (int, error) {
+	seen := make([]bool, len(s.slots))
+	n := 0
+	_, id := unpackHead(atomic.LoadUint64(&s.head))
+	for ; !s.invalid(id); id = atomic.LoadUint32(&s.slots[id].nextFree) {
+		if seen[id] {
+			return n, fmt.Errorf("slot %d is in free list twice", id)
+		}
+		seen[id] = true
+		n++
+		index, err := checkFreeEntry(buffer, poolIndex, entrySize, &s.slots[id])
+		if err != nil {
+			return n, fmt.Errorf("slot %d: %v", id, err)
+		}
+		e := (*EntryHeader)(unsafe.Pointer(&buffer[index*entrySize]))
+		if e.nextFree&IdMask != id {
+			return n, fmt.Errorf("slot %d: entry's slot index is %d", id, e.nextFree&IdMask)
+		}
+	}
+	if id&Invalid == 0 {
+		return n, fmt.Errorf("free list end with slot %d out of range", id)
+	}
+	return n, nil
+}
+
+func (b *bitmapPosition) validate(buffer []byte, poolIndex, entrySize int) (int, error) {
+	n := 0
+	for j := range b.free {
+		w := atomic.LoadUint64(&b.free[j])
+		if w == 0 {
+			continue
+		}
+		if atomic.LoadUint64(&b.summary[j/64])&(1<<uint(j%64)) == 0 {
+			return n, fmt.Errorf("word %d has free entries but not in summary", j)
+		}
+		for ; w != 0; w &= w - 1 {
+			i := uint32(j*64 + bits.TrailingZeros64(w))
+			if i >= b.cap {
+				return n, fmt.Errorf("bit %d out of cap %d is set", i, b.cap)
+			}
+			n++
+			eh := b.header(i)
+			if _, err := checkFreeEntry(buffer, poolIndex, entrySize, &eh); err != nil {
+				return n, fmt.Errorf("bit %d: %v", i, err)
+			}
+		}
+	}
+	return n, nil
+}
+
+func (r *ringEntryPosition) validate(buffer []byte, poolIndex, entrySize int) (int, error) {
+	head, tail := unpack(atomic.LoadUint64(&r.headtail))
+	n := head - tail
+	cap := uint32(len(r.ring))
+	if n > cap {
+		return 0, fmt.Errorf("ring head:%d, tail:%d, n:%d > cap:%d", head, tail, n, cap)
+	}
+	seen := make([]bool, cap)
+	for i := uint32(0); i < n; i++ {
+		eh := &r.ring[(tail+i)&(cap-1)]
+		if atomic.LoadUint32(&eh.nextFree) != tail+i+1 {
+			return int(n), fmt.Errorf("ring %d is not filled", (tail+i)&(cap-1))
+		}
+		index, err := checkFreeEntry(buffer, poolIndex, entrySize, eh)
+		if err != nil {
+			return int(n), fmt.Errorf("ring %d: %v", (tail+i)&(cap-1), err)
+		}
+		if seen[index] {
+			return int(n), fmt.Errorf("entry %d is in ring twice", index)
+		}
+		seen[index] = true
+	}
+	return int(n), nil
+}
@@ -0,0 +1,137 @@
+package cachePool
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+GetValueCtx 在没有可用对象时阻塞等待，直到有对象被PutEntry 放回或者ctx 被取消。
+waiter 按先来先服务排队，PutEntry 发现有waiter 时直接把entry 交给队头的waiter，不再放回pool，
+没有waiter 时只多一次atomic load，不影响非阻塞的GetValue/PutValue.
+
+per-P magazine: waiter 入队后再GetValue 一次，所有pool 都空时GetValue 会从所有magazine 偷(stealLocal)，
+所以入队前放进magazine 的entry 能被waiter 取到；PutEntry 放进magazine 后再检查一次waiter 数，
+有waiter 就从magazine 偷一个交给它，所以入队之后放进magazine 的entry 也不会丢失唤醒。
+放回pool(positioner) 也一样: PutEntry 之后再检查一次waiter 数，有waiter 就从这个pool 取一个交给它(handoffPool)，
+waiter 入队后的GetValue 和PutEntry 后的检查至少有一个能看到对方。
+*/
+type waiter struct {
+	ch chan *Entry //buffered 1, PutEntry never block on it
+}
+
+type waitQueue struct {
+	n int32 //waiter num, PutEntry check it lockless
+	sync.Mutex
+	waiters list.List
+}
+
+func (q *waitQueue) enqueue() (*list.Element, *waiter) {
+	w := &waiter{ch: make(chan *Entry, 1)}
+	q.Lock()
+	elem := q.waiters.PushBack(w)
+	atomic.AddInt32(&q.n, 1)
+	q.Unlock()
+	return elem, w
+}
+
+//remove waiter from queue, return false if it has been dequeued by handoff
+func (q *waitQueue) remove(elem *list.Element) bool {
+	q.Lock()
+	defer q.Unlock()
+	if elem.Value == nil {
+		return false
+	}
+	q.waiters.Remove(elem)
+	elem.Value = nil
+	atomic.AddInt32(&q.n, -1)
+	return true
+}
+
+//give the free entry to the first waiter, return false if there is no waiter
+func (q *waitQueue) handoff(e *Entry) bool {
+	if atomic.LoadInt32(&q.n) == 0 {
+		return false
+	}
+	q.Lock()
+	front := q.waiters.Front()
+	if front == nil {
+		q.Unlock()
+		return false
+	}
+	w := front.Value.(*waiter)
+	q.waiters.Remove(front)
+	front.Value = nil
+	atomic.AddInt32(&q.n, -1)
+	atomic.StoreUint32(&e.nextFree, e.nextFree|UsedFlag) //it is allocated to waiter now
+	w.ch <- e
+	q.Unlock()
+	return true
+}
+
+//GetValueCtx is like GetValue, but if there is no free value, it waits until
+//a value is put back or ctx is done
+func (cp *cachePool) GetValueCtx(ctx context.Context) (*Value, error) {
+	if v := cp.GetValue(); v != nil {
+		return v, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	elem, w := cp.waitq.enqueue()
+	//try again, the entry may be put back before we enqueue
+	if v := cp.GetValue(); v != nil {
+		if !cp.waitq.remove(elem) {
+			//have got an entry by handoff, give it to others
+			cp.PutEntry(<-w.ch)
+		}
+		return v, nil
+	}
+	select {
+	case e := <-w.ch:
+		cp.trackAlloc(e)
+		return &e.Value, nil
+	case <-ctx.Done():
+		if cp.waitq.remove(elem) {
+			return nil, ctx.Err()
+		}
+		//handoff happened at the same time
+		e := <-w.ch
+		cp.trackAlloc(e)
+		return &e.Value, nil
+	}
+}
+
+//handoffLocal is called after an entry is put to magazine, a waiter may have enqueued
+//after the handoff check, and its GetValue may miss the entry, so give it an entry of magazines
+func (cp *cachePool) handoffLocal() {
+	if atomic.LoadInt32(&cp.waitq.n) == 0 {
+		return
+	}
+	e := cp.stealLocal()
+	if e == nil {
+		return
+	}
+	if !cp.waitq.handoff(e) {
+		//the waiter have got one or gone
+		cp.freeEntry(e)
+	}
+}
+
+//handoffPool is called after entries are put to pool p, like handoffLocal, a waiter may have
+//enqueued after the handoff check and its GetValue may miss the entries, so give it an entry of p
+func (cp *cachePool) handoffPool(p *Pool) {
+	if atomic.LoadInt32(&cp.waitq.n) == 0 {
+		return
+	}
+	e := p.GetEntry()
+	if e == nil {
+		return
+	}
+	if !cp.waitq.handoff(e) {
+		//the waiter have got one or gone
+		cp.freeEntry(e)
+	}
+}
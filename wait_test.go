@@ -0,0 +1,80 @@
+package cachePool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+//goroutines share fewer values than them, every GetValueCtx must be woken up by a PutValue,
+//a lost wakeup make it time out although the value is free in pool
+func TestGetValueCtxWakeup(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		opts []Option
+	}{
+		{"pool", nil},
+		{"localCache", []Option{OptionWithLocalCache(4)}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			testGetValueCtxWakeup(t, c.opts...)
+		})
+	}
+}
+
+func testGetValueCtxWakeup(t *testing.T, opts ...Option) {
+	const goroutines = 4
+	rounds := 5000
+	if testing.Short() {
+		rounds = 1000
+	}
+	cp, err := NewCachePool(1, 1, append(opts, OptionWithAutoExtend(false))...)
+	if err != nil {
+		t.Fatalf("NewCachePool: %v", err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				v, err := cp.GetValueCtx(ctx)
+				cancel()
+				if err != nil {
+					t.Errorf("round %d: GetValueCtx: %v", i, err)
+					return
+				}
+				if err := cp.PutValue(v); err != nil {
+					t.Errorf("round %d: PutValue: %v", i, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := cp.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestGetValueCtxCancel(t *testing.T) {
+	cp, err := NewCachePool(1, 1, OptionWithAutoExtend(false))
+	if err != nil {
+		t.Fatalf("NewCachePool: %v", err)
+	}
+	v := cp.GetValue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if got, err := cp.GetValueCtx(ctx); got != nil || err != context.DeadlineExceeded {
+		t.Fatalf("GetValueCtx on empty pool: %v, %v", got, err)
+	}
+	//the waiter who gave up must not take the value put back later
+	if err := cp.PutValue(v); err != nil {
+		t.Fatalf("PutValue: %v", err)
+	}
+	if cp.GetValue() != v {
+		t.Fatalf("the value put back is lost")
+	}
+}